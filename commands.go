@@ -0,0 +1,268 @@
+// Sub commands of gotemplate
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sandwich-go/gotemplate/generator"
+)
+
+// commands are the sub commands, run with the arguments following
+// their name
+var commands = map[string]func(args []string){
+	"clean":   clean,
+	"verify":  verify,
+	"regen":   regen,
+	"migrate": migrate,
+	"wrap":    wrap,
+}
+
+// readInstances reads the instances in the manifest if set and in the
+// go:generate directives in dirs.  If no dirs are given then the dirs
+// of the manifest are used, or the current directory if none.  The
+// dirs may end in "/..." to include their subdirectories.
+func readInstances(manifest string, dirs []string) ([]generator.Options, []string) {
+	var instances []generator.Options
+	if manifest != "" {
+		m, err := generator.ReadManifest(manifest)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		instances = m.Options()
+		if len(dirs) == 0 {
+			for _, o := range instances {
+				dirs = append(dirs, o.Dir)
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	dirs, err := generator.ExpandDirs(dirs)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	for _, dir := range dirs {
+		directives, err := generator.ReadDirectives(dir)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		instances = append(instances, directives...)
+	}
+	for i := range instances {
+		instances[i].Logf = logf
+	}
+	return instances, dirs
+}
+
+// clean deletes the generated files which are no longer generated by
+// any go:generate directive or manifest entry
+func clean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	list := fs.Bool("l", false, "list the stale files rather than deleting them")
+	manifest := fs.String("config", "", "JSON manifest listing more instances to keep")
+	_ = fs.Parse(args)
+
+	instances, dirs := readInstances(*manifest, fs.Args())
+	stale, err := generator.New().Stale(context.Background(), dirs, instances)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	for _, name := range stale {
+		if *list {
+			fmt.Println(name)
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			fatalf("Failed to remove stale file: %v", err)
+		}
+		logf("Removed stale file %s", name)
+	}
+}
+
+// verify checks the generated files are up to date with the
+// go:generate directives and manifest entries
+func verify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	manifest := fs.String("config", "", "JSON manifest listing more instances to check")
+	_ = fs.Parse(args)
+
+	instances, _ := readInstances(*manifest, fs.Args())
+	verifyInstances(instances)
+}
+
+// verifyInstances generates instances without writing anything and
+// exits with an error showing the differences if any of the generated
+// files is out of date
+func verifyInstances(instances []generator.Options) {
+	g := generator.New()
+	outOfDate := 0
+	for _, o := range instances {
+		result, err := g.Generate(context.Background(), o)
+		if err != nil {
+			fatalf("%s %s: %v", o.Package, o.Instance, err)
+		}
+		for _, f := range result.Files {
+			if f.Changed {
+				outOfDate++
+				os.Stdout.Write(f.Diff())
+			}
+		}
+	}
+	if outOfDate > 0 {
+		fatalf("%d generated file(s) out of date", outOfDate)
+	}
+}
+
+// printInstances generates instances without writing anything,
+// printing the source to stdout and the names of the files it would
+// be written to in the log
+func printInstances(instances []generator.Options) {
+	g := generator.New()
+	for _, o := range instances {
+		result, err := g.Generate(context.Background(), o)
+		if err != nil {
+			fatalf("%s %s: %v", o.Package, o.Instance, err)
+		}
+		for _, f := range result.Files {
+			if f.Changed {
+				logf("Would write '%s'", f.Path)
+			} else {
+				logf("Unchanged '%s'", f.Path)
+			}
+			os.Stdout.Write(f.Content)
+		}
+	}
+}
+
+// regen regenerates every generated file found from the template and
+// arguments recorded in its header
+func regen(args []string) {
+	fs := flag.NewFlagSet("regen", flag.ExitOnError)
+	check := fs.Bool("check", false, "write nothing but fail with a diff if any generated file is out of date")
+	_ = fs.Parse(args)
+
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	dirs, err := generator.ExpandDirs(patterns)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var instances []generator.Options
+	for _, dir := range dirs {
+		found, missing, err := generator.ReadGenerated(dir)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		for _, name := range missing {
+			logf("%s: no gotemplate header - skipping", name)
+		}
+		instances = append(instances, found...)
+	}
+	for i := range instances {
+		instances[i].Logf = logf
+	}
+	if *check {
+		verifyInstances(instances)
+		return
+	}
+	g := generator.New()
+	for _, o := range instances {
+		if _, err := g.Instantiate(context.Background(), o); err != nil {
+			fatalf("%s %s: %v", o.Package, o.Instance, err)
+		}
+	}
+}
+
+// migrate converts a template package into a generic package,
+// reporting what it couldn't convert
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	outDir := fs.String("o", "", "the directory to write the generic package to")
+	dryRun := fs.Bool("n", false, "write nothing but print the generic package source")
+	force := fs.Bool("f", false, "write the generic package even if some of the template couldn't be converted")
+	verbose := fs.Bool("v", false, "Verbose - print lots of stuff")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fatalf("Need 1 argument, the template package")
+	}
+	if *outDir == "" && !*dryRun {
+		fatalf("Need -o to write the generic package or -n to print it")
+	}
+	opts := generator.MigrateOptions{
+		Package: fs.Arg(0),
+		OutDir:  *outDir,
+		Force:   *force,
+		Verbose: *verbose,
+		Logf:    logf,
+	}
+	g := generator.New()
+	var result generator.MigrateResult
+	var err error
+	if *dryRun {
+		result, err = g.GenerateMigration(context.Background(), opts)
+	} else {
+		result, err = g.Migrate(context.Background(), opts)
+	}
+	if err != nil {
+		fatalf("%s: %v", opts.Package, err)
+	}
+	if *dryRun {
+		for _, f := range result.Files {
+			logf("Would write '%s'", f.Path)
+			os.Stdout.Write(f.Content)
+		}
+	}
+	for _, u := range result.Unconverted {
+		logf("%v", u)
+	}
+	if len(result.Unconverted) > 0 && !*force && !*dryRun {
+		fatalf("%d construct(s) couldn't be converted so nothing was written - use -f to write anyway", len(result.Unconverted))
+	} else if len(result.Unconverted) > 0 {
+		fatalf("%d construct(s) couldn't be converted", len(result.Unconverted))
+	}
+}
+
+// wrap rewrites generated files as aliases and wrappers of a generic
+// version of their template, using the template and arguments
+// recorded in their headers
+func wrap(args []string) {
+	fs := flag.NewFlagSet("wrap", flag.ExitOnError)
+	genericPath := fs.String("generic", "", "import path of the generic version of the template")
+	_ = fs.Parse(args)
+
+	if *genericPath == "" || fs.NArg() == 0 {
+		fatalf("Need -generic and the generated files to wrap")
+	}
+	g := generator.New()
+	for _, name := range fs.Args() {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		p, err := generator.ReadProvenance(src)
+		if err != nil {
+			fatalf("%s: %v", name, err)
+		}
+		if p == nil {
+			fatalf("%s: no gotemplate header", name)
+		}
+		opts, err := p.Options(filepath.Dir(name))
+		if err != nil {
+			fatalf("%s: %v", name, err)
+		}
+		opts.Generic = *genericPath
+		opts.Logf = logf
+		if _, err := g.Instantiate(context.Background(), opts); err != nil {
+			fatalf("%s: %v", name, err)
+		}
+	}
+}
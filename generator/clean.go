@@ -0,0 +1,129 @@
+// Finds the generated files which are no longer generated
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IsGenerated returns true if src was generated by gotemplate
+func IsGenerated(src []byte) bool {
+	return bytes.HasPrefix(src, []byte(strings.TrimSpace(genHeader)+"\n"))
+}
+
+// FindGenerated returns the paths of the go files in dir which were
+// generated by gotemplate
+func FindGenerated(dir string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, name := range names {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", name, err)
+		}
+		if IsGenerated(src) {
+			paths = append(paths, name)
+		}
+	}
+	return paths, nil
+}
+
+// Stale returns the paths of the files generated by gotemplate in dirs
+// which none of instances would generate.  The go:generate directives
+// elsewhere in the modules of dirs which write into them with -o count
+// as instances too.
+func (g *Generator) Stale(ctx context.Context, dirs []string, instances []Options) ([]string, error) {
+	into, err := directivesInto(dirs)
+	if err != nil {
+		return nil, err
+	}
+	expected := map[string]bool{}
+	for _, opts := range append(instances, into...) {
+		paths, err := g.OutputPaths(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", opts.Package, opts.Instance, err)
+		}
+		for _, p := range paths {
+			expected[p] = true
+		}
+	}
+	var stale []string
+	seen := map[string]bool{}
+	for _, d := range dirs {
+		dir, err := filepath.Abs(d)
+		if err != nil {
+			return nil, fmt.Errorf("bad directory %q: %w", d, err)
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		paths, err := FindGenerated(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if !expected[p] {
+				stale = append(stale, p)
+			}
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// directivesInto returns the gotemplate directives in the modules of
+// dirs, outside dirs, which write their output into one of dirs
+func directivesInto(dirs []string) ([]Options, error) {
+	inDirs := map[string]bool{}
+	roots := map[string]bool{}
+	for _, d := range dirs {
+		dir, err := filepath.Abs(d)
+		if err != nil {
+			return nil, fmt.Errorf("bad directory %q: %w", d, err)
+		}
+		inDirs[dir] = true
+		roots[moduleRoot(dir)] = true
+	}
+	var patterns []string
+	for root := range roots {
+		patterns = append(patterns, filepath.Join(root, "..."))
+	}
+	sort.Strings(patterns)
+	moduleDirs, err := ExpandDirs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	var into []Options
+	for _, dir := range moduleDirs {
+		if inDirs[dir] {
+			continue
+		}
+		directives, err := ReadDirectives(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range directives {
+			if o.OutDir == "" {
+				continue
+			}
+			outDir := o.OutDir
+			if !filepath.IsAbs(outDir) {
+				outDir = filepath.Join(dir, outDir)
+			}
+			if inDirs[outDir] {
+				into = append(into, o)
+			}
+		}
+	}
+	return into, nil
+}
@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestStale(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": manifestTemplate})
+	defer cleanup()
+
+	for name, src := range map[string]string{
+		"gen.go":                  "package main\n\n//go:generate gotemplate -r input IntSet(int)\n",
+		"gotemplate_IntSet.go":    genHeader + "package main\n",
+		"gotemplate_OldSet.go":    genHeader + "package main\n",
+		"gotemplate_my_set.go":    genHeader + "package main\n",
+		"gotemplate_handmade.go":  "package main\n",
+		"gotemplate_IntSet_x.txt": genHeader,
+	} {
+		err := ioutil.WriteFile(path.Join(output, name), []byte(src), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write %q: %v", name, err)
+		}
+	}
+
+	instances, err := ReadDirectives(output)
+	if err != nil {
+		t.Fatalf("ReadDirectives failed: %v", err)
+	}
+	// an instance from a manifest keeps gotemplate_my_set.go
+	instances = append(instances, Options{Dir: output, Package: "input", Instance: "mySet(int)"})
+
+	stale, err := New().Stale(context.Background(), []string{output}, instances)
+	if err != nil {
+		t.Fatalf("Stale failed: %v", err)
+	}
+	want := []string{path.Join(output, "gotemplate_OldSet.go")}
+	if !reflect.DeepEqual(stale, want) {
+		t.Errorf("Stale = %q, want %q", stale, want)
+	}
+}
+
+func TestStaleOutDir(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": manifestTemplate})
+	defer cleanup()
+	coll := path.Join(output, "internal", "coll")
+	if err := os.MkdirAll(coll, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(output, "gen.go"), "package main\n\n//go:generate gotemplate -o internal/coll -r input StrSet(string)\n")
+	writeFile(t, path.Join(coll, "coll.go"), "package coll\n")
+	writeFile(t, path.Join(coll, "gotemplate_StrSet.go"), genHeader+"package coll\n")
+	writeFile(t, path.Join(coll, "gotemplate_OldSet.go"), genHeader+"package coll\n")
+
+	// The directive in the parent package keeps gotemplate_StrSet.go
+	instances, err := ReadDirectives(coll)
+	if err != nil {
+		t.Fatalf("ReadDirectives failed: %v", err)
+	}
+	stale, err := New().Stale(context.Background(), []string{coll}, instances)
+	if err != nil {
+		t.Fatalf("Stale failed: %v", err)
+	}
+	want := []string{path.Join(coll, "gotemplate_OldSet.go")}
+	if !reflect.DeepEqual(stale, want) {
+		t.Errorf("Stale = %q, want %q", stale, want)
+	}
+}
@@ -0,0 +1,209 @@
+// Checks the names an instance declares against the destination package
+
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// destinationFiles returns the paths of the go files in the
+// destination directory, except those this instance generates.  Those
+// an earlier run generated for this instance under another name are
+// stale, so are left out too and returned as stale.
+func (t *template) destinationFiles() (paths, stale []string, err error) {
+	outputs, err := t.outputPaths()
+	if err != nil {
+		return nil, nil, err
+	}
+	ours := map[string]bool{}
+	for _, name := range outputs {
+		ours[name] = true
+	}
+	names, err := filepath.Glob(filepath.Join(t.opts.OutDir, "*.go"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		if ours[name] {
+			continue
+		}
+		if t.isStale(name) {
+			stale = append(stale, name)
+			continue
+		}
+		paths = append(paths, name)
+	}
+	return paths, stale, nil
+}
+
+// isStale returns true if gotemplate generated the file name for this
+// instance.  Files with a "// gotemplate:" header must record the same
+// template and instance.  Those written by versions of gotemplate
+// before the header must declare the name of the instance.
+func (t *template) isStale(name string) bool {
+	src, err := os.ReadFile(name)
+	if err != nil || !IsGenerated(src) {
+		return false
+	}
+	p, err := ReadProvenance(src)
+	if err != nil {
+		return false
+	}
+	if p != nil {
+		return p.Template == t.templatePath() && p.Instance == t.Name
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), name, src, parser.SkipObjectResolution)
+	if err != nil {
+		return false
+	}
+	for _, id := range instanceDecls([]*ast.File{f}) {
+		if id.Name == t.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationDecls returns the positions of the package level
+// declarations of the destination package, as type checked by the go
+// command's rules.  The outputs of this instance are left out.
+func (t *template) destinationDecls() (map[string]token.Position, error) {
+	if !hasGoFiles(t.opts.OutDir) {
+		return nil, nil
+	}
+	_, stale, err := t.destinationFiles()
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := t.outputPaths()
+	if err != nil {
+		return nil, err
+	}
+	// Blank the files of this instance which are already there
+	for _, name := range stale {
+		t.opts.Logf("%s: ignoring %s which is an earlier output of %s - remove it", t.Package, name, t.Name)
+	}
+	overlay := map[string][]byte{}
+	for _, name := range append(outputs, stale...) {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(abs); err == nil {
+			overlay[abs] = []byte("package " + t.NewPackage + "\n")
+		}
+	}
+	conf := &packages.Config{
+		Context: t.ctx,
+		Mode:    packages.LoadSyntax,
+		Dir:     t.opts.OutDir,
+		Tests:   true,
+		Overlay: overlay,
+	}
+	pkgs, err := packages.Load(conf, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load destination package: %w", err)
+	}
+	// The test variant has the declarations of the test files too
+	var pkg *packages.Package
+	for _, p := range pkgs {
+		if p.Name == t.NewPackage && p.Types != nil && (pkg == nil || len(p.Syntax) > len(pkg.Syntax)) {
+			pkg = p
+		}
+	}
+	decls := map[string]token.Position{}
+	if pkg == nil {
+		return decls, nil
+	}
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if name == "_" || !obj.Pos().IsValid() {
+			continue
+		}
+		decls[name] = pkg.Fset.Position(obj.Pos())
+	}
+	return decls, nil
+}
+
+// instanceDecls returns the package level identifiers files declare
+func instanceDecls(files []*ast.File) (ids []*ast.Ident) {
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.Name != "init" {
+					ids = append(ids, d.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.ValueSpec:
+						ids = append(ids, s.Names...)
+					case *ast.TypeSpec:
+						ids = append(ids, s.Name)
+					}
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// checkCollisions checks the renamed declarations in files don't
+// collide with those of the destination package.  If the Disambiguate
+// option is set then colliding unexported names are renamed by adding
+// a number, otherwise a CollisionError is returned.
+func (t *template) checkCollisions(fset *token.FileSet, info *types.Info, files []*ast.File) error {
+	dest, err := t.destinationDecls()
+	if err != nil {
+		return err
+	}
+	ids := instanceDecls(files)
+	taken := map[string]bool{}
+	for _, id := range ids {
+		taken[id.Name] = true
+	}
+	var collisions []Collision
+	for _, id := range ids {
+		other, found := dest[id.Name]
+		if !found {
+			continue
+		}
+		obj := info.Defs[id]
+		if t.opts.Disambiguate && obj != nil && !ast.IsExported(id.Name) {
+			name := id.Name
+			for n := 2; taken[name] || isDeclared(dest, name); n++ {
+				name = id.Name + strconv.Itoa(n)
+			}
+			t.opts.Logf("%s: renaming %s to %s as it is declared at %s", t.Name, id.Name, name, other)
+			taken[name] = true
+			replaceIdentifier(info, obj, name)
+			continue
+		}
+		collisions = append(collisions, Collision{
+			Name:        id.Name,
+			Template:    fset.Position(id.Pos()),
+			Destination: other,
+		})
+	}
+	if len(collisions) > 0 {
+		return &CollisionError{Instance: t.Name, Collisions: collisions}
+	}
+	return nil
+}
+
+// isDeclared returns true if name is in decls
+func isDeclared(decls map[string]token.Position, name string) bool {
+	_, found := decls[name]
+	return found
+}
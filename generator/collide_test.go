@@ -0,0 +1,119 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+const collideTemplate = `package tt
+
+// template type Set(A)
+type A int
+
+type Set map[A]struct{}
+
+func NewSet() Set { return Set{} }
+
+func (s Set) Add(a A) { s[a] = struct{}{} }
+
+func utilityFunc() {}
+
+func init() { utilityFunc() }
+`
+
+func TestCollisions(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": collideTemplate})
+	defer cleanup()
+	writeFile(t, path.Join(output, "util.go"), `package main
+
+func utilityFuncIntSet() {}
+
+func NewIntSet() {}
+
+func init() {}
+`)
+	writeFile(t, path.Join(output, "other_test.go"), "package main_test\n\nfunc utilityFuncIntSet2() {}\n")
+	writeFile(t, path.Join(output, "ignored.go"), "//go:build ignore\n\npackage main\n\nfunc main() {}\n")
+
+	opts := Options{Dir: output, Package: "input", Instance: "IntSet(int)"}
+	_, err := New().Generate(context.Background(), opts)
+	var collision *CollisionError
+	if !errors.As(err, &collision) {
+		t.Fatalf("Expecting CollisionError, got %v", err)
+	}
+	if len(collision.Collisions) != 2 {
+		t.Fatalf("Expecting 2 collisions, got %v", collision)
+	}
+	for _, c := range collision.Collisions {
+		if path.Base(c.Template.Filename) != "main.go" || path.Base(c.Destination.Filename) != "util.go" {
+			t.Errorf("Bad positions %+v", c)
+		}
+	}
+	if msg := err.Error(); !strings.Contains(msg, "utilityFuncIntSet from ") || !strings.Contains(msg, "util.go:3:6") {
+		t.Errorf("Bad error message %q", msg)
+	}
+
+	// Unexported names can be renamed but exported ones can't
+	opts.Disambiguate = true
+	_, err = New().Generate(context.Background(), opts)
+	if !errors.As(err, &collision) || len(collision.Collisions) != 1 || collision.Collisions[0].Name != "NewIntSet" {
+		t.Fatalf("Expecting collision on NewIntSet, got %v", err)
+	}
+	writeFile(t, path.Join(output, "util.go"), "package main\n\nfunc utilityFuncIntSet() {}\n")
+	result, err := New().Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{"func utilityFuncIntSet2() {}", "func init() { utilityFuncIntSet2() }"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expecting %q in\n%s", want, got)
+		}
+	}
+}
+
+func TestCollisionsStale(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": collideTemplate})
+	defer cleanup()
+	opts := Options{Dir: output, Package: "input", Instance: "IntSet(int)"}
+	result, err := New().Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	src := result.Files[0].Content
+
+	// Earlier outputs of the instance under other names are ignored,
+	// whether or not they have a provenance header
+	writeFile(t, path.Join(output, "gotemplate_IntSet.go"), string(src))
+	writeFile(t, path.Join(output, "old_int_set.go"), genHeader+"\npackage main\n\ntype IntSet map[int]bool\n\nfunc NewIntSet() {}\n")
+	var logs []string
+	opts.Logf = func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) }
+	if _, err := New().Generate(context.Background(), opts); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(logs) != 2 || !strings.Contains(logs[0], "gotemplate_IntSet.go which is an earlier output of IntSet") {
+		t.Errorf("Expecting stale files to be reported, got %q", logs)
+	}
+
+	// Outputs of other instances still collide
+	writeFile(t, path.Join(output, "old_int_set.go"), genHeader+"\npackage main\n\nfunc NewIntSet() {}\n")
+	writeFile(t, path.Join(output, "gotemplate_IntSet.go"), strings.Replace(string(src), `"instance":"IntSet"`, `"instance":"OtherSet"`, 1))
+	_, err = New().Generate(context.Background(), opts)
+	var collision *CollisionError
+	if !errors.As(err, &collision) {
+		t.Fatalf("Expecting CollisionError, got %v", err)
+	}
+}
+
+// writeFile writes contents to name failing the test on error
+func writeFile(t *testing.T, name, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(name, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write %q: %v", name, err)
+	}
+}
@@ -0,0 +1,217 @@
+// Rewrites the identifiers in the comments of the template
+
+package generator
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// commentRenames returns the new names of the identifiers of the
+// template which should be rewritten in comments.  decls maps the
+// objects of the template to their names in the template.
+//
+// Parameters whose argument has spaces in map to "" as long
+// expressions such as func literals would make a mess of the comments.
+func (t *template) commentRenames(info *types.Info, files []*ast.File, decls map[types.Object]string) map[string]string {
+	renames := map[string]string{}
+	for _, id := range instanceDecls(files) {
+		if name, found := decls[info.Defs[id]]; found && name != id.Name {
+			renames[name] = id.Name
+		}
+	}
+	for param, arg := range t.templateArgsMap {
+		if strings.ContainsAny(arg, " \t\n") {
+			arg = ""
+		}
+		renames[param] = arg
+	}
+	return renames
+}
+
+// rewriteComments replaces the identifiers in renames in the comments
+// of files.  decls maps the objects of the template to their names in
+// the template and params the template parameters to their arguments.
+//
+// Doc links like [A] are rewritten wherever they are, losing the
+// brackets if the replacement isn't a name.  Identifiers renamed to ""
+// are left alone but lose the brackets of their doc links.
+//
+// The renamed declarations are rewritten wherever they are a whole
+// word with a capital letter in, as lower case words might be English,
+// except after a "." as they would be selectors, in quotes and in the
+// package doc, which describes the template rather than the instance.
+// The parameters are only rewritten as the name a declaration's doc
+// comment starts with, perhaps after "A", "An" or "The", as they tend
+// to be single letters or the names of methods too.  Indented code
+// blocks and the "// template" comments are left alone.
+func rewriteComments(info *types.Info, files []*ast.File, decls map[types.Object]string, params map[string]string, renames map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+	var names []string
+	for name := range renames {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	// Longest first so the longest name matches
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	links := regexp.MustCompile(`\[(` + strings.Join(names, "|") + `)\]`)
+	for _, f := range files {
+		docs := declDocs(info, f, decls)
+		for _, cg := range f.Comments {
+			for i, c := range cg.List {
+				if matchTemplateType.MatchString(c.Text) || matchConstraint.MatchString(c.Text) || matchFormat.MatchString(c.Text) {
+					continue
+				}
+				if cg != f.Doc {
+					c.Text = rewriteWords(c.Text, params, renames)
+				}
+				if name, isDoc := docs[cg]; isDoc && i == 0 {
+					c.Text = rewriteLeading(c.Text, name, renames[name])
+				}
+				c.Text = rewriteLinks(links, c.Text, renames)
+			}
+		}
+	}
+}
+
+// declDocs returns the doc comments of the package level declarations
+// of f along with the names in the template of what they declare
+func declDocs(info *types.Info, f *ast.File, decls map[types.Object]string) map[*ast.CommentGroup]string {
+	docs := map[*ast.CommentGroup]string{}
+	add := func(doc *ast.CommentGroup, id *ast.Ident) {
+		if name, found := decls[info.Defs[id]]; found && doc != nil {
+			docs[doc] = name
+		}
+	}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				add(d.Doc, d.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				var ids []*ast.Ident
+				var doc *ast.CommentGroup
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					ids, doc = []*ast.Ident{s.Name}, s.Doc
+				case *ast.ValueSpec:
+					ids, doc = s.Names, s.Doc
+				}
+				if doc == nil && len(d.Specs) == 1 {
+					doc = d.Doc
+				}
+				for _, id := range ids {
+					add(doc, id)
+				}
+			}
+		}
+	}
+	return docs
+}
+
+// matchLeading matches the start of a doc comment up to the name it
+// documents
+var matchLeading = regexp.MustCompile(`^(//\s*|/\*\s*)((?:A|An|The)\s+)?([\pL_][\pL\pN_]*)\b`)
+
+// rewriteLeading replaces name with newName if the doc comment text
+// starts with it
+func rewriteLeading(text, name, newName string) string {
+	m := matchLeading.FindStringSubmatchIndex(text)
+	if m == nil || newName == "" || text[m[6]:m[7]] != name {
+		return text
+	}
+	return text[:m[6]] + newName + text[m[7]:]
+}
+
+// matchWord matches the words and quotes of a comment
+var matchWord = regexp.MustCompile(`[\pL_][\pL\pN_]*|"|''|` + "``")
+
+// rewriteWords replaces the whole words of text which are the renamed
+// declarations in renames, rather than the params, except in indented
+// code blocks, doc links, selectors, quotes and words without a capital
+func rewriteWords(text string, params, renames map[string]string) string {
+	if isCodeLine(text) {
+		return text
+	}
+	var b strings.Builder
+	last, quoted := 0, false
+	for _, m := range matchWord.FindAllStringIndex(text, -1) {
+		word := text[m[0]:m[1]]
+		switch word {
+		case `"`:
+			quoted = !quoted
+			continue
+		case "``":
+			quoted = true
+			continue
+		case "''":
+			quoted = false
+			continue
+		}
+		newName, found := renames[word]
+		if !found || newName == "" || quoted || strings.ToLower(word) == word {
+			continue
+		}
+		if _, isParam := params[word]; isParam {
+			continue
+		}
+		if m[0] > 0 && strings.ContainsRune(".[", rune(text[m[0]-1])) {
+			continue
+		}
+		b.WriteString(text[last:m[0]])
+		b.WriteString(newName)
+		last = m[1]
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// rewriteLinks replaces the doc links re finds in text, except in
+// indented code blocks
+func rewriteLinks(re *regexp.Regexp, text string, renames map[string]string) string {
+	if isCodeLine(text) {
+		return text
+	}
+	return re.ReplaceAllStringFunc(text, func(link string) string {
+		name := renames[link[1:len(link)-1]]
+		if name == "" {
+			return link[1 : len(link)-1]
+		}
+		if isDocLink(name) {
+			return "[" + name + "]"
+		}
+		return name
+	})
+}
+
+// isCodeLine returns true if the // comment text is a line of an
+// indented code block
+func isCodeLine(text string) bool {
+	line, found := strings.CutPrefix(text, "//")
+	return found && (strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "  "))
+}
+
+// isDocLink returns true if name can be written as a doc link, so is
+// a name or a qualified name
+func isDocLink(name string) bool {
+	pkg, sel, found := strings.Cut(name, ".")
+	if !found {
+		return token.IsIdentifier(name)
+	}
+	return token.IsIdentifier(pkg) && token.IsIdentifier(sel)
+}
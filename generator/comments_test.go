@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCommentsTemplates(t *testing.T) {
+	for _, test := range []struct {
+		pkg      string
+		instance string
+		want     []string
+		unwanted []string
+	}{
+		{
+			pkg:      "../treemap",
+			instance: "intStringTreeMap(int, string)",
+			want: []string{
+				"// intStringTreeMap is the red-black tree based map\n",
+				"// Key returns a key at an iterator's position\n",
+				"// Value returns a value at an iterator's position\n",
+				"//\t        fmt.Println(it.Key(), it.Value())\n",
+				"// Less returns a < b\n",
+			},
+			unwanted: []string{"it.int()", "// int returns", "// string returns"},
+		},
+		{
+			pkg:      "../sort",
+			instance: "SortF(float64, lt)",
+			want: []string{
+				"// Package sort provides primitives for sorting slices of A.\n",
+				"// SortF sorts data.\n",
+				"// data.Less and data.swap. The sort is not guaranteed to be stable.\n",
+				"// Once b meets c, can swap the \"= pivot\" sections\n",
+				"// ``Engineering a Sort Function,'' SP&E November 1993.\n",
+				"// Insertion sort\n",
+			},
+			unwanted: []string{"swapSortF the", "SortF Function", "data.lt", "data.swapSortF"},
+		},
+		{
+			pkg:      "../set",
+			instance: "mySet(string)",
+			want:     []string{"// Package set is a template Set type\n"},
+			unwanted: []string{"template mySet type"},
+		},
+	} {
+		opts := Options{Package: test.pkg, Instance: test.instance, OutDir: t.TempDir(), PackageName: "main"}
+		result, err := New().Generate(context.Background(), opts)
+		if err != nil {
+			t.Errorf("%s: %v", test.pkg, err)
+			continue
+		}
+		got := string(result.Files[0].Content)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: expecting %q in\n%s", test.pkg, want, got)
+			}
+		}
+		for _, unwanted := range test.unwanted {
+			if strings.Contains(got, unwanted) {
+				t.Errorf("%s: unexpected %q in\n%s", test.pkg, unwanted, got)
+			}
+		}
+	}
+}
+
+func TestRewriteWords(t *testing.T) {
+	params := map[string]string{"A": "int", "Key": "string"}
+	renames := map[string]string{"A": "int", "Key": "string", "Cache": "MyCache", "NewCache": "NewMyCache", "swap": "swapMyCache"}
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"// NewCache makes a Cache", "// NewMyCache makes a MyCache"},
+		{"// Get returns the A in the Cache, or the zero A", "// Get returns the A in the MyCache, or the zero A"},
+		{"// Key returns the key", "// Key returns the key"},
+		{"// CacheSize and Caches aren't Cache", "// CacheSize and Caches aren't MyCache"},
+		{"// see c.Cache and [Cache]", "// see c.Cache and [Cache]"},
+		{"// swap the elements", "// swap the elements"},
+		{`// "a Cache" or ` + "``a Cache''" + ` but Cache`, `// "a Cache" or ` + "``a Cache''" + ` but MyCache`},
+		{"//\tc := NewCache()", "//\tc := NewCache()"},
+	} {
+		if got := rewriteWords(test.in, params, renames); got != test.want {
+			t.Errorf("rewriteWords(%q): want %q got %q", test.in, test.want, got)
+		}
+	}
+}
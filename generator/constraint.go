@@ -0,0 +1,293 @@
+// Checks the arguments of an instance against the constraints the
+// template declares on its parameters
+
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// matchConstraint matches "// template constraint Param Constraint"
+var matchConstraint = regexp.MustCompile(`^//\s*template\s+constraint\s+(\w+)\s+(.*?)\s*$`)
+
+// constraint is what the argument of a template parameter must
+// satisfy.  kind is one of "comparable", "ordered", "integer" or
+// "implements" in which case typ is the interface, or "" in which case
+// the argument must be a value assignable to typ.
+type constraint struct {
+	param string
+	kind  string
+	typ   string    // the type as written in the template
+	pos   token.Pos // of the comment
+}
+
+func (c *constraint) String() string {
+	return strings.TrimSpace(c.kind + " " + c.typ)
+}
+
+// findConstraints reads the "// template constraint" comments of files.
+// Constraints on the parameters of templates other than the chosen one
+// are ignored.
+func (t *template) findConstraints(files []*ast.File, params map[string]bool) ([]*constraint, error) {
+	var constraints []*constraint
+	for _, f := range files {
+		for _, cg := range f.Comments {
+			for _, x := range cg.List {
+				matches := matchConstraint.FindStringSubmatch(x.Text)
+				if matches == nil {
+					continue
+				}
+				c := &constraint{param: matches[1], pos: x.Pos()}
+				if !params[c.param] {
+					return nil, fmt.Errorf("constraint %q is on %s which isn't a template parameter", x.Text, c.param)
+				}
+				kind, typ, _ := strings.Cut(matches[2], " ")
+				switch kind {
+				case "comparable", "ordered", "integer":
+					if typ != "" {
+						return nil, fmt.Errorf("constraint %q: unexpected %q after %s", x.Text, typ, kind)
+					}
+					c.kind = kind
+				case "implements":
+					c.kind, c.typ = kind, strings.TrimSpace(typ)
+				default:
+					c.typ = matches[2]
+				}
+				for _, param := range t.templateArgs {
+					if param == c.param {
+						constraints = append(constraints, c)
+						break
+					}
+				}
+			}
+		}
+	}
+	return constraints, nil
+}
+
+// checkConstraints checks the arguments against the constraints of the
+// parameters in the destination package, returning a ConstraintError
+// if one isn't satisfied.
+//
+// The types in the constraints are type checked in the template where
+// they are written and then with the arguments substituted for the
+// parameters in the destination package.  Parameters which take their
+// defaults aren't checked, and neither are arguments which can't be
+// type checked in the destination package, such as those using names
+// the instance will declare, as the compiler will check those.  Those
+// are warned about through Logf.
+func (t *template) checkConstraints(pkg *typedPackage, constraints []*constraint) error {
+	if len(constraints) == 0 {
+		return nil
+	}
+	args := map[string]string{}
+	for i, param := range t.templateArgs {
+		args[param] = t.qualify(t.Args[i])
+	}
+	scope := pkg.types.Scope()
+
+	// Type check the constraints in the template and substitute the
+	// arguments for the parameters
+	wants := map[*constraint]string{}
+	paths := map[string]bool{}
+	for _, c := range constraints {
+		if c.typ == "" {
+			continue
+		}
+		expr, err := parser.ParseExpr(c.typ)
+		if err != nil {
+			return fmt.Errorf("bad constraint %s on %s: %w", c, c.param, err)
+		}
+		info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}, Uses: map[*ast.Ident]types.Object{}}
+		if err := types.CheckExpr(pkg.fset, pkg.types, c.pos, expr, info); err != nil {
+			return fmt.Errorf("bad constraint %s on %s: %w", c, c.param, err)
+		}
+		if !info.Types[expr].IsType() {
+			return fmt.Errorf("bad constraint %s on %s: not a type", c, c.param)
+		}
+		if c.kind == "implements" && !types.IsInterface(info.Types[expr].Type) {
+			return fmt.Errorf("bad constraint %s on %s: not an interface", c, c.param)
+		}
+		substituted := true
+		ast.Inspect(expr, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			switch obj := info.Uses[id].(type) {
+			case *types.PkgName:
+				paths[obj.Imported().Path()] = true
+			case nil:
+			default:
+				if arg, isParam := args[id.Name]; isParam && obj.Parent() == scope {
+					if arg == "" {
+						substituted = false
+					}
+					id.Name = "(" + arg + ")"
+				}
+			}
+			return true
+		})
+		if substituted {
+			wants[c] = types.ExprString(expr)
+		}
+	}
+
+	dest, err := t.destinationPackage(paths)
+	if err != nil {
+		return err
+	}
+	for _, c := range constraints {
+		arg := args[c.param]
+		if arg == "" {
+			continue
+		}
+		tv, err := types.Eval(token.NewFileSet(), dest, token.NoPos, arg)
+		if err != nil {
+			t.opts.Logf("%s: not checking %s satisfies %s: %v", t.Name, arg, c, err)
+			continue
+		}
+		var want types.Type
+		if c.typ != "" {
+			typ, found := wants[c]
+			if !found {
+				t.debugf("Not checking %s satisfies %s as it uses a default", arg, c)
+				continue
+			}
+			wantTV, err := types.Eval(token.NewFileSet(), dest, token.NoPos, typ)
+			if err != nil {
+				t.opts.Logf("%s: not checking %s satisfies %s: %v", t.Name, arg, c, err)
+				continue
+			}
+			want = wantTV.Type
+		}
+		if reason := unsatisfied(c, tv, want); reason != "" {
+			return &ConstraintError{Template: t.templateName, Param: c.param, Arg: arg, Constraint: c.String(), Reason: reason}
+		}
+	}
+	return nil
+}
+
+// unsatisfied returns why the argument whose type and value is tv
+// doesn't satisfy c, or "" if it does.  want is the type in c with the
+// arguments substituted.
+func unsatisfied(c *constraint, tv types.TypeAndValue, want types.Type) string {
+	switch c.kind {
+	case "comparable", "ordered", "implements":
+		if !tv.IsType() {
+			return "not a type"
+		}
+	case "integer":
+		if tv.Value == nil || tv.Value.Kind() != constant.Int {
+			return "not an integer constant"
+		}
+		return ""
+	default:
+		if tv.IsType() {
+			return fmt.Sprintf("a type, expecting a value of type %s", want)
+		}
+		if !types.AssignableTo(tv.Type, want) {
+			return fmt.Sprintf("has type %s which can't be used as %s", tv.Type, want)
+		}
+		return ""
+	}
+	switch c.kind {
+	case "comparable":
+		if !types.Comparable(tv.Type) {
+			return "not comparable"
+		}
+	case "ordered":
+		basic, ok := tv.Type.Underlying().(*types.Basic)
+		if !ok || basic.Info()&types.IsOrdered == 0 {
+			return "not ordered"
+		}
+	case "implements":
+		iface := want.Underlying().(*types.Interface)
+		if method, wrongType := types.MissingMethod(tv.Type, iface, true); method != nil {
+			if wrongType {
+				return fmt.Sprintf("method %s has the wrong type", method.Name())
+			}
+			return fmt.Sprintf("missing method %s", method.Name())
+		}
+	}
+	return ""
+}
+
+// destinationPackage returns a package for type checking the arguments
+// in, which has the declarations of the destination package and its
+// imports along with the packages whose paths are in paths
+func (t *template) destinationPackage(paths map[string]bool) (*types.Package, error) {
+	dir := t.opts.Dir
+	var patterns []string
+	if hasGoFiles(t.opts.OutDir) {
+		dir = t.opts.OutDir
+		patterns = append(patterns, ".")
+	}
+	for path := range paths {
+		patterns = append(patterns, path)
+	}
+	for path := range t.importNames {
+		if !paths[path] {
+			patterns = append(patterns, path)
+		}
+	}
+	sort.Strings(patterns)
+	dest := types.NewPackage("gotemplate/check", t.NewPackage)
+	if len(patterns) == 0 {
+		return dest, nil
+	}
+	conf := &packages.Config{
+		Context: t.ctx,
+		Mode:    packages.LoadSyntax,
+		Dir:     dir,
+	}
+	pkgs, err := packages.Load(conf, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load destination package: %w", err)
+	}
+	scope := dest.Scope()
+	addImport := func(imp *types.Package) {
+		if scope.Lookup(imp.Name()) == nil {
+			scope.Insert(types.NewPkgName(token.NoPos, dest, imp.Name(), imp))
+		}
+	}
+	for _, p := range pkgs {
+		if p.Types == nil {
+			continue
+		}
+		if _, imported := t.importNames[p.PkgPath]; !paths[p.PkgPath] && !imported {
+			// The destination package - use its path so its
+			// unexported names are visible
+			dest = types.NewPackage(p.PkgPath, t.NewPackage)
+			scope = dest.Scope()
+			for _, name := range p.Types.Scope().Names() {
+				scope.Insert(p.Types.Scope().Lookup(name))
+			}
+			for _, imp := range p.Types.Imports() {
+				addImport(imp)
+			}
+		}
+	}
+	for _, p := range pkgs {
+		if p.Types == nil {
+			continue
+		}
+		if name, found := t.importNames[p.PkgPath]; found {
+			scope.Insert(types.NewPkgName(token.NoPos, dest, name, p.Types))
+		}
+		if paths[p.PkgPath] {
+			addImport(p.Types)
+		}
+	}
+	return dest, nil
+}
@@ -0,0 +1,98 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+)
+
+const constraintTemplate = `package tt
+
+import "fmt"
+
+// template type Table(K, V, Less, N)
+// template constraint K comparable
+// template constraint V implements fmt.Stringer
+// template constraint Less func(K, K) bool
+// template constraint N integer
+type K int
+type V fmt.Stringer
+
+func Less(a, b K) bool { return a < b }
+
+const N = 8
+
+type Table struct {
+	m    map[K]V
+	less func(K, K) bool
+	buf  [N]K
+}
+
+func NewTable() *Table { return &Table{m: map[K]V{}, less: Less} }
+`
+
+func TestConstraints(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": constraintTemplate})
+	defer cleanup()
+	writeFile(t, path.Join(output, "types.go"), `package main
+
+type name string
+
+func (n name) String() string { return string(n) }
+
+func lessName(a, b name) bool { return a < b }
+
+func lessInt(a, b int) bool { return a < b }
+`)
+
+	for _, test := range []struct {
+		args       string
+		param      string
+		constraint string
+		warning    string
+	}{
+		{args: "T(name, name, lessName, 4)"},
+		{args: "T(name, *Unknown, lessName, 4)", warning: "not checking *Unknown satisfies implements fmt.Stringer"},
+		{args: "T([]int, name, lessName, 4)", param: "K", constraint: "comparable"},
+		{args: "T(name, int, lessName, 4)", param: "V", constraint: "implements fmt.Stringer"},
+		{args: "T(name, name, lessInt, 4)", param: "Less", constraint: "func(K, K) bool"},
+		{args: "T(name, name, name, 4)", param: "Less", constraint: "func(K, K) bool"},
+		{args: "T(name, name, lessName, 4.5)", param: "N", constraint: "integer"},
+	} {
+		var warnings []string
+		logf := func(format string, args ...interface{}) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+		_, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: test.args, Logf: logf})
+		if test.warning != "" && !strings.Contains(strings.Join(warnings, "\n"), test.warning) {
+			t.Errorf("%s: expecting warning %q in %q", test.args, test.warning, warnings)
+		}
+		var e *ConstraintError
+		if test.param == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.args, err)
+			}
+		} else if !errors.As(err, &e) {
+			t.Errorf("%s: expecting ConstraintError, got %v", test.args, err)
+		} else if e.Param != test.param || e.Constraint != test.constraint {
+			t.Errorf("%s: wrong constraint failed %v", test.args, err)
+		}
+	}
+}
+
+func TestBadConstraint(t *testing.T) {
+	for _, constraint := range []string{
+		"// template constraint B comparable",
+		"// template constraint A comparable extra",
+		"// template constraint A implements int",
+		"// template constraint A Undefined",
+	} {
+		output, cleanup := makeModules(t, map[string]string{"main.go": "package tt\n\n// template type Set(A)\n" + constraint + "\ntype A int\n\ntype Set map[A]bool\n"})
+		_, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "MySet(int)"})
+		cleanup()
+		if err == nil {
+			t.Errorf("%s: expecting error", constraint)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Reads the go:generate directives which run gotemplate
+
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const goGenerate = "//go:generate "
+
+// ReadDirectives reads the "//go:generate gotemplate" directives in the
+// go files in dir and returns the Options for each of them
+func ReadDirectives(dir string) ([]Options, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var opts []Options
+	for _, name := range names {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", name, err)
+		}
+		if IsGenerated(src) {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(src))
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, goGenerate) {
+				continue
+			}
+			o, ok, err := ParseDirective(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, lineNumber, err)
+			}
+			if ok {
+				o.Dir = dir
+				opts = append(opts, o)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", name, err)
+		}
+	}
+	return opts, nil
+}
+
+// ParseDirective parses a "//go:generate" line returning ok set if it
+// runs gotemplate.  The Dir of the Options returned is not set.
+func ParseDirective(line string) (opts Options, ok bool, err error) {
+	words, err := splitQuoted(strings.TrimPrefix(line, goGenerate))
+	if err != nil {
+		return opts, false, err
+	}
+	// Find the gotemplate command which may be run as "gotemplate" or
+	// "go run github.com/sandwich-go/gotemplate@version"
+	for i, word := range words {
+		if name, _, _ := strings.Cut(word, "@"); path.Base(name) == "gotemplate" {
+			words = words[i+1:]
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return opts, false, nil
+	}
+	opts, args, err := parseFlags(words)
+	if err != nil {
+		return opts, false, fmt.Errorf("bad gotemplate directive: %w", err)
+	}
+	if len(args) != 2 {
+		return opts, false, fmt.Errorf("bad gotemplate directive: need 2 arguments, package and parameters")
+	}
+	opts.Package = args[0]
+	opts.Instance = args[1]
+	return opts, true, nil
+}
+
+// parseFlags parses the gotemplate command line flags at the start of
+// words returning the remaining arguments
+func parseFlags(words []string) (opts Options, args []string, err error) {
+	fs := flag.NewFlagSet("gotemplate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts.RegisterFlags(fs)
+	if err := fs.Parse(words); err != nil {
+		return opts, nil, err
+	}
+	return opts, fs.Args(), nil
+}
+
+// splitQuoted splits line into words as go generate does - words are
+// separated by spaces and may be double quoted Go strings
+func splitQuoted(line string) (words []string, err error) {
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return words, nil
+		}
+		if line[0] != '"' {
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			words = append(words, line[:i])
+			line = line[i:]
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("bad quoted string in %q", line)
+		}
+		word, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+		line = line[len(quoted):]
+	}
+}
@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"testing"
+)
+
+func TestParseDirective(t *testing.T) {
+	for _, test := range []struct {
+		line     string
+		ok       bool
+		err      bool
+		pkg      string
+		instance string
+		check    func(Options) bool
+	}{
+		{
+			line:     `//go:generate gotemplate "github.com/sandwich-go/gotemplate/set" mySet(string)`,
+			ok:       true,
+			pkg:      "github.com/sandwich-go/gotemplate/set",
+			instance: "mySet(string)",
+		},
+		{
+			line:     `//go:generate gotemplate -r -t -outfmt gen_%v "github.com/sandwich-go/gotemplate/sort" "SortGt(string, func(a, b string) bool { return a > b })"`,
+			ok:       true,
+			pkg:      "github.com/sandwich-go/gotemplate/sort",
+			instance: "SortGt(string, func(a, b string) bool { return a > b })",
+			check:    func(o Options) bool { return o.RawName && o.Test && o.OutFmt == "gen_%v" },
+		},
+		{
+			line:     `//go:generate go run github.com/sandwich-go/gotemplate@v1.0.0 -split pkg X(int)`,
+			ok:       true,
+			pkg:      "pkg",
+			instance: "X(int)",
+			check:    func(o Options) bool { return o.Split && o.OutFmt == DefaultOutFmt },
+		},
+		{
+			line: `//go:generate stringer -type=Pill`,
+		},
+		{
+			line: `//go:generate gotemplate pkg`,
+			err:  true,
+		},
+		{
+			line: `//go:generate gotemplate -nope pkg X(int)`,
+			err:  true,
+		},
+		{
+			line: `//go:generate gotemplate "pkg X(int)`,
+			err:  true,
+		},
+	} {
+		opts, ok, err := ParseDirective(test.line)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error state: %v", test.line, err)
+			continue
+		}
+		if ok != test.ok {
+			t.Errorf("%s: ok = %v", test.line, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if opts.Package != test.pkg || opts.Instance != test.instance {
+			t.Errorf("%s: got %q %q", test.line, opts.Package, opts.Instance)
+		}
+		if test.check != nil && !test.check(opts) {
+			t.Errorf("%s: wrong flags %+v", test.line, opts)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Finds instances which duplicate another in the same package
+
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// instanceKey identifies what an instance with provenance p generates
+// into dir so that equivalent instances can be found
+func instanceKey(dir string, p *Provenance) (string, error) {
+	opts, _, err := parseFlags(p.Flags)
+	if err != nil {
+		return "", fmt.Errorf("bad flags in gotemplate header: %w", err)
+	}
+	parts := []string{dir, p.Template, p.Definition, strings.Join(p.Args, "\x01"),
+		strings.Join(opts.Keep, ","), strconv.FormatBool(opts.Prune), strings.Join(opts.Imports, ","), opts.Generic}
+	return strings.Join(parts, "\x00"), nil
+}
+
+// findDuplicate returns the name of another instance generated into
+// the destination directory, by this Generator or already on disk,
+// which has the same template and arguments, or "" if there isn't one.
+// Instances which are aliases of others are ignored.
+func (t *template) findDuplicate() (string, error) {
+	key, err := instanceKey(t.opts.OutDir, t.provenance)
+	if err != nil {
+		return "", err
+	}
+	if name, found := t.g.instances[key]; found && name != t.Name {
+		return name, nil
+	}
+	outputs, err := t.outputPaths()
+	if err != nil {
+		return "", err
+	}
+	ours := map[string]bool{}
+	for _, name := range outputs {
+		ours[name] = true
+	}
+	paths, err := FindGenerated(t.opts.OutDir)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range paths {
+		if ours[name] {
+			continue
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %q: %w", name, err)
+		}
+		p, err := ReadProvenance(src)
+		if err != nil || p == nil || p.Alias != "" || p.Instance == t.Name {
+			continue
+		}
+		if k, err := instanceKey(t.opts.OutDir, p); err == nil && k == key {
+			return p.Instance, nil
+		}
+	}
+	return "", nil
+}
+
+// isTestFunc returns true if fn has a testing parameter like the
+// functions isTestDecl finds
+func isTestFunc(fn *types.Func) bool {
+	params := fn.Type().(*types.Signature).Params()
+	for i := 0; i < params.Len(); i++ {
+		if strings.HasPrefix(params.At(i).Type().String(), "*testing.") {
+			return true
+		}
+	}
+	return false
+}
+
+// outputAlias writes a file which declares the top level names of the
+// instance as aliases of those of the instance called other.  decls
+// maps the objects of the template to their names in the template.
+// Functions are declared as functions which call those of other.  Vars
+// can't be aliased so are left out, which the file notes.
+func (t *template) outputAlias(other string, fset *token.FileSet, info *types.Info, files []*ast.File, decls map[types.Object]string) error {
+	type alias struct {
+		pos  token.Pos
+		decl string
+	}
+	var aliases []alias
+	var vars []string
+	for _, id := range instanceDecls(files) {
+		obj := info.Defs[id]
+		name, found := decls[obj]
+		if !found {
+			continue
+		}
+		otherName := t.instanceName(name, other)
+		switch obj.(type) {
+		case *types.TypeName:
+			aliases = append(aliases, alias{id.Pos(), fmt.Sprintf("type %s = %s", id.Name, otherName)})
+		case *types.Const:
+			aliases = append(aliases, alias{id.Pos(), fmt.Sprintf("const %s = %s", id.Name, otherName)})
+		case *types.Func:
+			if isTestFunc(obj.(*types.Func)) {
+				continue
+			}
+			fd := funcDecl(files, id)
+			if fd == nil {
+				continue
+			}
+			args := nameParams(fd)
+			decl, err := forwardFunc(fset, fd, fmt.Sprintf("%s(%s)", otherName, strings.Join(args, ", ")))
+			if err != nil {
+				return err
+			}
+			aliases = append(aliases, alias{id.Pos(), decl})
+		default:
+			vars = append(vars, id.Name)
+		}
+	}
+	if len(vars) > 0 {
+		t.opts.Logf("%s: can't alias vars %s of %s", t.Name, strings.Join(vars, ", "), other)
+	}
+	sort.SliceStable(aliases, func(i, j int) bool {
+		return aliases[i].pos < aliases[j].pos
+	})
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "package %s\n\n", t.NewPackage)
+	fmt.Fprintf(&src, "// %s is the same instance as %s\n", t.Name, other)
+	if len(vars) > 0 {
+		fmt.Fprintf(&src, "//\n// The vars %s can't be aliased so are left out\n", strings.Join(vars, ", "))
+	}
+	src.WriteString("\n")
+	for _, a := range aliases {
+		fmt.Fprintf(&src, "%s\n\n", a.decl)
+	}
+	t.provenance.Alias = other
+	return t.output(t.outputName(".go"), src.Bytes())
+}
@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+)
+
+const duplicateTemplate = `package tt
+
+// template type Set(A)
+type A int
+
+const maxSet = 10
+
+var countSet int
+
+type Set map[A]struct{}
+
+func NewSet() Set { return Set{} }
+
+func (s Set) Add(a A) { s[a] = struct{}{} }
+`
+
+func TestDuplicates(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": duplicateTemplate})
+	defer cleanup()
+
+	var warnings []string
+	logf := func(format string, args ...interface{}) {
+		warnings = append(warnings, format)
+	}
+	g := New()
+	for _, instance := range []string{"StringSet(string)", "IntSet(int)"} {
+		opts := Options{Dir: output, Package: "input", Instance: instance, RawName: true, Logf: logf}
+		if _, err := g.Instantiate(context.Background(), opts); err != nil {
+			t.Fatalf("Instantiate failed: %v", err)
+		}
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected warnings %q", warnings)
+	}
+
+	// Spotted by the same Generator
+	opts := Options{Dir: output, Package: "input", Instance: "Names(string)", RawName: true, Logf: logf}
+	result, err := g.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(string(result.Files[0].Content), "type Names map[string]struct{}") {
+		t.Errorf("Expecting a warning and a copy, got %q", warnings)
+	}
+
+	// Spotted from the files on disk
+	opts.Alias = true
+	result, err = New().Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	want := `package main
+
+// Names is the same instance as StringSet
+//
+// The vars countNames can't be aliased so are left out
+
+const maxNames = maxStringSet
+
+type Names = StringSet
+
+func NewNames() Names {
+	return NewStringSet()
+}
+`
+	got := string(result.Files[0].Content)
+	if p, _ := ReadProvenance(result.Files[0].Content); p == nil || p.Alias != "StringSet" {
+		t.Errorf("Alias not recorded in header\n%s", got)
+	}
+	if got = got[strings.Index(got, "\n\n")+2:]; got != want {
+		t.Errorf("Output wrong\nGot:\n%s\nWant:\n%s", got, want)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[1], "can't alias vars") {
+		t.Errorf("Expecting a warning about vars, got %q", warnings)
+	}
+
+	// Declarations from different files keep the order of the files
+	output2, cleanup2 := makeModules(t, map[string]string{
+		"a.go": "package tt\n\n// template type Pair(A)\ntype A int\n\nfunc NewPair(a, b A) Pair {\n\treturn Pair{a, b}\n}\n\nfunc Swap(p Pair) Pair { return Pair{p[1], p[0]} }\n",
+		"b.go": "package tt\n\ntype Pair [2]A\n\nfunc Join(ps ...Pair) []A { return nil }\n",
+	})
+	defer cleanup2()
+	for _, instance := range []string{"IntPair(int)", "Ints(int)"} {
+		opts := Options{Dir: output2, Package: "input", Instance: instance, Alias: true}
+		result, err = New().Instantiate(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("Instantiate failed: %v", err)
+		}
+	}
+	got = string(result.Files[0].Content)
+	want = "func NewInts(a, b int) Ints {\n\treturn NewIntPair(a, b)\n}\n\nfunc SwapInts(p Ints) Ints {\n\treturn SwapIntPair(p)\n}\n\ntype Ints = IntPair\n\nfunc JoinInts(ps ...Ints) []int {\n\treturn JoinIntPair(ps...)\n}\n"
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("Output wrong\nGot:\n%s\nWant suffix:\n%s", got, want)
+	}
+
+	// Regenerating the original ignores the alias
+	opts = Options{Dir: output, Package: "input", Instance: "StringSet(string)", RawName: true, Alias: true}
+	result, err = New().Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if name := path.Base(result.Files[0].Path); name != "gotemplate_StringSet.go" || !strings.Contains(string(result.Files[0].Content), "map[string]struct{}") {
+		t.Errorf("Original instance not generated in full")
+	}
+}
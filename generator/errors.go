@@ -0,0 +1,126 @@
+package generator
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ParseError is returned when an instantiation such as "MySet(string)"
+// or a "// template type" comment can't be parsed
+type ParseError struct {
+	Input string // the text which failed to parse
+	Err   error  // the underlying error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %q: %v", e.Input, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// TypeCheckError is returned when the template package has errors, as
+// reported by the go command or found type checking it
+type TypeCheckError struct {
+	Package string           // the template package
+	Errs    []packages.Error // the errors reported for it
+}
+
+func (e *TypeCheckError) Error() string {
+	var msgs []string
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("type checking error in %s: %s", e.Package, strings.Join(msgs, "; "))
+}
+
+// ArityError is returned when the number of arguments supplied doesn't
+// match the number of parameters of the template
+type ArityError struct {
+	Template string // the name of the template, eg "Set"
+	Want     int    // number of parameters the template has
+	Got      int    // number of arguments supplied
+}
+
+func (e *ArityError) Error() string {
+	return fmt.Sprintf("wrong number of arguments - template %s is expecting %d but %d supplied", e.Template, e.Want, e.Got)
+}
+
+// ArgumentError is returned when a named argument doesn't match the
+// parameters of the template or a parameter has no argument
+type ArgumentError struct {
+	Template string // the name of the template, eg "Heap"
+	Name     string // the name of the parameter
+	Reason   string // what is wrong
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("bad argument %s for template %s: %s", e.Name, e.Template, e.Reason)
+}
+
+// ConstraintError is returned when an argument doesn't satisfy a
+// "// template constraint" on its parameter
+type ConstraintError struct {
+	Template   string // the name of the template, eg "Set"
+	Param      string // the name of the parameter
+	Arg        string // the argument supplied
+	Constraint string // the constraint, eg "comparable"
+	Reason     string // why it isn't satisfied
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("argument %s for %s of template %s doesn't satisfy %s: %s", e.Arg, e.Param, e.Template, e.Constraint, e.Reason)
+}
+
+// ImportError is returned when a package the arguments import can't be
+// found from the destination package
+type ImportError struct {
+	Path string // the import path
+	Err  error  // the underlying error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("can't import %q for the template arguments: %v", e.Path, e.Err)
+}
+
+func (e *ImportError) Unwrap() error { return e.Err }
+
+// MissingDefinitionError is returned when the template package has no
+// "// template type" comment, or when Name is set, when the template
+// package doesn't declare the type or func named by the comment
+type MissingDefinitionError struct {
+	Package string // the template package
+	Name    string // the template name if the comment was found
+}
+
+func (e *MissingDefinitionError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("didn't find template definition in %s", e.Package)
+	}
+	return fmt.Sprintf("no definition for template type %q in %s", e.Name, e.Package)
+}
+
+// Collision is a name declared by both an instance and the
+// destination package
+type Collision struct {
+	Name        string         // the name in the instance
+	Template    token.Position // where the template declares it
+	Destination token.Position // where the destination package declares it
+}
+
+// CollisionError is returned when an instance would declare names the
+// destination package already declares
+type CollisionError struct {
+	Instance   string // the instance name, eg "MySet"
+	Collisions []Collision
+}
+
+func (e *CollisionError) Error() string {
+	var msgs []string
+	for _, c := range e.Collisions {
+		msgs = append(msgs, fmt.Sprintf("%s from %s is already declared at %s", c.Name, c.Template, c.Destination))
+	}
+	return fmt.Sprintf("name collision instantiating %s: %s", e.Instance, strings.Join(msgs, "; "))
+}
@@ -0,0 +1,299 @@
+// Package generator instantiates gotemplate template packages.
+//
+// It is the engine behind the gotemplate command and may be used
+// directly by build tooling and tests.
+package generator
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultOutFmt is the default format of the output file names
+const DefaultOutFmt = "gotemplate_%v"
+
+// Options control a single template instantiation
+type Options struct {
+	// Dir is the directory of the package the template is
+	// instantiated into and the template package is resolved from.
+	// It defaults to the current directory.
+	Dir string
+
+	// Package is the import path of the template package
+	Package string
+
+	// OutDir is the directory the output files are written to,
+	// relative to Dir if not absolute. It defaults to Dir.
+	OutDir string
+
+	// PackageName is the name of the package the output files are
+	// in. It defaults to the package already in OutDir or, if there
+	// isn't one yet, the name of OutDir.
+	PackageName string
+
+	// Instance is the instantiation, eg "MySet(string)"
+	Instance string
+
+	// OutFmt is the format of the output file name which must
+	// contain a single %v verb to be replaced by the instance name.
+	// It defaults to DefaultOutFmt.
+	OutFmt string
+
+	// RawName uses the instance name as is in the output file name
+	// rather than converting it to snake case
+	RawName bool
+
+	// Test writes the testing functions of the template to a
+	// _test.go file
+	Test bool
+
+	// Split writes one output file per template source file instead
+	// of merging them into one
+	Split bool
+
+	// Keep lists the declarations of the instance, as "Name" or
+	// "Type.Method", to keep along with everything they use.  The
+	// other declarations are removed.
+	Keep []string
+
+	// Prune removes the declarations of the instance which the
+	// destination package doesn't use, keeping all the methods of the
+	// types it does.  It may be combined with Keep.
+	Prune bool
+
+	// Disambiguate renames the unexported names of the instance
+	// which the destination package already declares rather than
+	// returning a CollisionError
+	Disambiguate bool
+
+	// Alias makes an instance which has the same template and
+	// arguments as another instance in the same package declare its
+	// names as aliases of the other's instead of duplicating it.
+	// Functions call the other's and vars are left out.
+	Alias bool
+
+	// Imports lists the packages the arguments use as "alias=path" so
+	// an argument like "ids.UserID" uses the package with that path.
+	// Arguments may also give the path itself as in
+	// "github.com/acme/ids".UserID.
+	Imports []string
+
+	// Generic is the import path of a generic package equivalent to
+	// the template, eg one made by Migrate.  The instance is written
+	// as aliases of its types and functions which call its functions
+	// rather than as a copy of the template.
+	Generic string
+
+	// Verbose sends debugging output to Logf
+	Verbose bool
+
+	// Logf receives warnings and, if Verbose is set, debugging
+	// output. It may be nil.
+	Logf func(format string, args ...interface{})
+}
+
+// RegisterFlags registers the command line flags of gotemplate which
+// set opts on fs
+func (opts *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&opts.Verbose, "v", false, "Verbose - print lots of stuff")
+	fs.StringVar(&opts.OutDir, "o", "", "the directory to write the output files to (default the current directory)")
+	fs.StringVar(&opts.PackageName, "pkg", "", "the package name of the output files (default the package in the output directory)")
+	fs.StringVar(&opts.OutFmt, "outfmt", DefaultOutFmt, "the format of the output file; must contain a single instance of the %v verb\n"+
+		"\twhich will be replaced with the template instance name")
+	fs.BoolVar(&opts.RawName, "r", false, "raw name, not snake case name")
+	fs.BoolVar(&opts.Test, "t", false, "has test file")
+	fs.BoolVar(&opts.Split, "split", false, "write one output file per template source file instead of merging them")
+	fs.Var((*listFlag)(&opts.Keep), "keep", "comma separated `names` of the declarations to keep, as Name or Type.Method, removing the unused rest")
+	fs.BoolVar(&opts.Prune, "prune", false, "remove the declarations the destination package doesn't use")
+	fs.BoolVar(&opts.Alias, "alias", false, "declare aliases of an instance with the same template and arguments in the package rather than duplicating it; vars are left out")
+	fs.BoolVar(&opts.Disambiguate, "disambiguate", false, "rename unexported names which the destination package already declares")
+	fs.Var((*listFlag)(&opts.Imports), "import", "comma separated `alias=path` imports of the packages the arguments use")
+	fs.StringVar(&opts.Generic, "generic", "", "import `path` of a generic version of the template to alias and wrap rather than copying the template")
+}
+
+// listFlag is a flag.Value holding a comma separated list
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// File is a generated file
+type File struct {
+	Path     string // where the file is written
+	Content  []byte // the generated source
+	Previous []byte // the file on disk, nil if it doesn't exist
+	Changed  bool   // whether Content differs from the file on disk
+}
+
+// Diff returns a unified diff from the file on disk to the generated
+// file or nil if they are the same
+func (f *File) Diff() []byte {
+	return diff(f.Path, f.Previous, f.Path+" (generated)", f.Content)
+}
+
+// Result describes the outcome of an instantiation
+type Result struct {
+	Files []File
+}
+
+// Generator instantiates templates.  It loads each template package
+// only once however many times it is instantiated.
+type Generator struct {
+	cache     map[string]*loadedPackage
+	instances map[string]string // names of the instances generated by key
+}
+
+// New makes a Generator
+func New() *Generator {
+	return &Generator{
+		cache:     make(map[string]*loadedPackage),
+		instances: make(map[string]string),
+	}
+}
+
+// Instantiate instantiates the template package described by opts and
+// writes the output files which have changed.
+func Instantiate(ctx context.Context, opts Options) (Result, error) {
+	return New().Instantiate(ctx, opts)
+}
+
+// Instantiate instantiates the template package described by opts and
+// writes the output files which have changed.
+func (g *Generator) Instantiate(ctx context.Context, opts Options) (Result, error) {
+	result, err := g.Generate(ctx, opts)
+	if err != nil {
+		return result, err
+	}
+	return result, writeFiles(result.Files, opts.debugf)
+}
+
+// writeFiles writes the files which have changed
+func writeFiles(files []File, debugf func(format string, args ...interface{})) error {
+	for _, f := range files {
+		if f.Changed {
+			if err := os.MkdirAll(filepath.Dir(f.Path), 0777); err != nil {
+				return fmt.Errorf("unable to make output directory: %w", err)
+			}
+			if err := os.WriteFile(f.Path, f.Content, 0666); err != nil {
+				return fmt.Errorf("unable to write to %q: %w", f.Path, err)
+			}
+			debugf("Written '%s'", f.Path)
+		}
+	}
+	return nil
+}
+
+// readExisting reads the file at path which the generated file would
+// replace, returning nil if there isn't one
+func readExisting(path string) ([]byte, error) {
+	curr, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot open existing file: %w", err)
+	}
+	return curr, nil
+}
+
+// Generate instantiates the template package described by opts but
+// doesn't write anything.  The files which differ from those on disk
+// are marked as Changed.
+func (g *Generator) Generate(ctx context.Context, opts Options) (Result, error) {
+	var result Result
+	if err := opts.setDefaults(); err != nil {
+		return result, err
+	}
+	t, err := newTemplate(ctx, g, opts)
+	if err != nil {
+		return result, err
+	}
+	if err := t.instantiate(); err != nil {
+		return result, err
+	}
+	for _, f := range t.files {
+		curr, err := readExisting(f.Path)
+		if err != nil {
+			return result, err
+		}
+		f.Previous = curr
+		f.Changed = !bytes.Equal(curr, f.Content)
+		result.Files = append(result.Files, f)
+	}
+	return result, nil
+}
+
+// Changed returns true if any of the files has changed
+func (r *Result) Changed() bool {
+	for _, f := range r.Files {
+		if f.Changed {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputPaths returns the paths of all the files that instantiating
+// opts could write, without instantiating it
+func (g *Generator) OutputPaths(ctx context.Context, opts Options) ([]string, error) {
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+	t, err := newTemplate(ctx, g, opts)
+	if err != nil {
+		return nil, err
+	}
+	return t.outputPaths()
+}
+
+// Log if Verbose set
+func (opts *Options) debugf(format string, args ...interface{}) {
+	if opts.Verbose && opts.Logf != nil {
+		opts.Logf(format, args...)
+	}
+}
+
+// setDefaults fills in the defaults and checks opts
+func (opts *Options) setDefaults() error {
+	if opts.Dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("couldn't get wd: %w", err)
+		}
+		opts.Dir = cwd
+	}
+	if opts.OutFmt == "" {
+		opts.OutFmt = DefaultOutFmt
+	}
+	// verify that OutFmt contains exactly one occurrence of the %v verb
+	// and no other occurences of %
+	if c := strings.Replace(opts.OutFmt, "%v", "", 1); c == opts.OutFmt ||
+		strings.Contains(c, "%") {
+		return fmt.Errorf("invalid outfile format %q", opts.OutFmt)
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...interface{}) {}
+	}
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return fmt.Errorf("bad directory %q: %w", opts.Dir, err)
+	}
+	opts.Dir = dir
+	if opts.OutDir == "" {
+		opts.OutDir = opts.Dir
+	} else if !filepath.IsAbs(opts.OutDir) {
+		opts.OutDir = filepath.Join(opts.Dir, opts.OutDir)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": manifestTemplate})
+	defer cleanup()
+
+	opts := Options{Dir: output, Package: "input", Instance: "IntSet(int)", RawName: true}
+	g := New()
+	result, err := g.Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	if len(result.Files) != 1 || !result.Changed() {
+		t.Fatalf("Expecting one new file, got %+v", result.Files)
+	}
+
+	// Nothing to do the second time round
+	result, err = g.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Changed() {
+		t.Errorf("Expecting no changes")
+	}
+
+	// Make the file on disk out of date
+	name := path.Join(output, "gotemplate_IntSet.go")
+	old := []byte(strings.Replace(string(result.Files[0].Content), "map[int]", "map[int64]", 1))
+	if err := ioutil.WriteFile(name, old, 0600); err != nil {
+		t.Fatalf("Failed to write %q: %v", name, err)
+	}
+	result, err = g.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !result.Changed() {
+		t.Fatalf("Expecting changes")
+	}
+	diff := string(result.Files[0].Diff())
+	if !strings.Contains(diff, "-type IntSet map[int64]struct{}") || !strings.Contains(diff, "+type IntSet map[int]struct{}") {
+		t.Errorf("Bad diff:\n%s", diff)
+	}
+
+	// Generate mustn't have written anything
+	curr, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read %q: %v", name, err)
+	}
+	if !bytes.Equal(curr, old) {
+		t.Errorf("Generate wrote the file")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": manifestTemplate})
+	defer cleanup()
+
+	opts := Options{Dir: output, Package: "input", Instance: "IntSet(int)", Test: true, OutFmt: "gen_%v"}
+	result, err := Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	src := result.Files[0].Content
+	if !IsGenerated(src) {
+		t.Errorf("Not detected as generated:\n%s", src)
+	}
+	p, err := ReadProvenance(src)
+	if err != nil {
+		t.Fatalf("ReadProvenance failed: %v", err)
+	}
+	want := &Provenance{
+		Template:  "input",
+		Hash:      hashSources([]string{"main.go"}, [][]byte{[]byte(manifestTemplate)}),
+		Instance:  "IntSet",
+		Args:      []string{"int"},
+		Flags:     []string{"-outfmt", "gen_%v", "-t"},
+		Generator: Version,
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("Provenance = %+v, want %+v", p, want)
+	}
+
+	// Files without a provenance header
+	for _, src := range []string{"package main\n", genHeader + "\npackage main\n"} {
+		p, err := ReadProvenance([]byte(src))
+		if p != nil || err != nil {
+			t.Errorf("%q: got %v, %v", src, p, err)
+		}
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": manifestTemplate})
+	defer cleanup()
+
+	// Use the template module from a workspace instead of a replace
+	t.Setenv("GOFLAGS", "") // -mod can't be used in workspace mode
+	dir := path.Dir(output)
+	for name, contents := range map[string]string{
+		path.Join(output, "go.mod"): "module output\n\ngo 1.22\n",
+		path.Join(dir, "go.work"):   "go 1.22\n\nuse (\n\t./input\n\t./output\n)\n",
+	} {
+		if err := ioutil.WriteFile(name, []byte(contents), 0600); err != nil {
+			t.Fatalf("Failed to write %q: %v", name, err)
+		}
+	}
+
+	_, err := Instantiate(context.Background(), Options{Dir: output, Package: "input", Instance: "IntSet(int)", RawName: true})
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	checkOutput(t, path.Join(output, "gotemplate_IntSet.go"), `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type IntSet map[int]struct{}
+`)
+}
+
+func TestLocalTemplate(t *testing.T) {
+	output, cleanup := makeModules(t, nil)
+	defer cleanup()
+
+	// Keep an unpublished template next to the code using it
+	tmpl := path.Join(output, "internal", "templates", "set")
+	if err := os.MkdirAll(tmpl, 0700); err != nil {
+		t.Fatalf("Failed to make dir %q: %v", tmpl, err)
+	}
+	for name, contents := range map[string]string{
+		"set.go":       manifestTemplate,
+		"set_test.go":  "package tt\n\nimport \"testing\"\n\nfunc TestSet(t *testing.T) {}\n",
+		"skip_nope.go": "//go:build nope\n\npackage tt\n\nfunc skipped() {}\n",
+	} {
+		if err := ioutil.WriteFile(path.Join(tmpl, name), []byte(contents), 0600); err != nil {
+			t.Fatalf("Failed to write %q: %v", name, err)
+		}
+	}
+
+	for _, test := range []struct {
+		pkg  string
+		name string
+	}{
+		{pkg: "./internal/templates/set", name: "DirSet"},
+		{pkg: "./internal/templates/set/set.go", name: "FileSet"},
+		{pkg: tmpl, name: "AbsSet"},
+	} {
+		opts := Options{Dir: output, Package: test.pkg, Instance: test.name + "(int)", RawName: true}
+		if _, err := Instantiate(context.Background(), opts); err != nil {
+			t.Fatalf("%s: Instantiate failed: %v", test.pkg, err)
+		}
+		checkOutput(t, path.Join(output, "gotemplate_"+test.name+".go"), `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type `+test.name+` map[int]struct{}
+`)
+	}
+
+	opts := Options{Dir: output, Package: "./internal/templates/potato", Instance: "PotatoSet(int)"}
+	if _, err := Instantiate(context.Background(), opts); err == nil {
+		t.Errorf("Expecting error for missing template directory")
+	}
+}
+
+func TestOutDir(t *testing.T) {
+	output, cleanup := makeModules(t, nil)
+	defer cleanup()
+
+	tmpl := path.Join(output, "tmpl")
+	preview := path.Join(output, "preview")
+	for _, dir := range []string{tmpl, preview} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatalf("Failed to make dir %q: %v", dir, err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tmpl, "set.go"), []byte(manifestTemplate), 0600); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	opts := Options{Dir: output, Package: "./tmpl", Instance: "IntSet(int)", OutDir: "preview", RawName: true}
+	result, err := New().Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if len(result.Files) != 1 {
+		t.Fatalf("Expecting one file, got %+v", result.Files)
+	}
+	f := result.Files[0]
+	if want := path.Join(preview, "gotemplate_IntSet.go"); f.Path != want {
+		t.Errorf("Path = %q, want %q", f.Path, want)
+	}
+	if _, err := os.Stat(f.Path); !os.IsNotExist(err) {
+		t.Errorf("Generate wrote %q", f.Path)
+	}
+	// The empty output directory gets a package named after it
+	if !bytes.Contains(f.Content, []byte("\npackage preview\n")) {
+		t.Errorf("Wrong package clause:\n%s", f.Content)
+	}
+	p, err := ReadProvenance(f.Content)
+	if err != nil || p == nil {
+		t.Fatalf("ReadProvenance failed: %v", err)
+	}
+	if p.Template != "../tmpl" {
+		t.Errorf("Template = %q, want it relative to the output directory", p.Template)
+	}
+}
+
+func TestOutPackage(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": manifestTemplate})
+	defer cleanup()
+
+	// Generate into a directory which doesn't exist yet
+	opts := Options{Dir: output, Package: "input", Instance: "IntSet(int)", OutDir: "internal/collections", RawName: true}
+	if _, err := Instantiate(context.Background(), opts); err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	name := path.Join(output, "internal", "collections", "gotemplate_IntSet.go")
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("Failed to read %q: %v", name, err)
+	}
+	if !bytes.Contains(src, []byte("\npackage collections\n")) {
+		t.Errorf("Wrong package clause:\n%s", src)
+	}
+
+	// The package in the directory is used from now on
+	opts.Instance = "StringSet(string)"
+	opts.PackageName = "collections"
+	result, err := Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	p, err := ReadProvenance(result.Files[0].Content)
+	if err != nil || p == nil {
+		t.Fatalf("ReadProvenance failed: %v", err)
+	}
+	if want := []string{"-pkg", "collections", "-r"}; !reflect.DeepEqual(p.Flags, want) {
+		t.Errorf("Flags = %q, want %q", p.Flags, want)
+	}
+	opts.PackageName = "potato"
+	if _, err := Instantiate(context.Background(), opts); err == nil {
+		t.Errorf("Expecting error for mismatched package name")
+	}
+
+	// A new directory with an explicit package name
+	opts = Options{Dir: output, Package: "input", Instance: "IntSet(int)", OutDir: "gen-sets", PackageName: "sets"}
+	result, err = Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	if !bytes.Contains(result.Files[0].Content, []byte("\npackage sets\n")) {
+		t.Errorf("Wrong package clause:\n%s", result.Files[0].Content)
+	}
+}
@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const genericTemplate = `package tt
+
+// template type Set(A)
+// template constraint A comparable
+type A int
+
+type Set map[A]bool
+
+type Item struct{ A }
+
+func Conv(x interface{}) A { return A(x.(A)) }
+`
+
+func TestGenericArgs(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": genericTemplate})
+	defer cleanup()
+	input := path.Join(path.Dir(output), "input")
+	if err := os.Mkdir(path.Join(input, "ids"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(input, "ids", "ids.go"), "package ids\n\ntype Pair[K comparable, V any] struct {\n\tK K\n\tV V\n}\n")
+	writeFile(t, path.Join(output, "box.go"), "package main\n\ntype box[T any] struct{ v T }\n")
+
+	for _, test := range []struct {
+		instance string
+		imports  []string
+		want     []string
+	}{
+		{
+			instance: "BoxSet(box[int])",
+			want: []string{
+				"type BoxSet map[box[int]]bool",
+				"type ItemBoxSet struct{ box[int] }",
+				"return box[int](x.(box[int]))",
+			},
+		},
+		{
+			instance: `PairSet("input/ids".Pair[int, string])`,
+			want: []string{
+				`import "input/ids"`,
+				"type PairSet map[ids.Pair[int, string]]bool",
+				"type ItemPairSet struct{ ids.Pair[int, string] }",
+			},
+		},
+		{
+			instance: "NestedSet(ids.Pair[box[int], ids.Pair[string, int]])",
+			imports:  []string{"ids=input/ids"},
+			want:     []string{"type NestedSet map[ids.Pair[box[int], ids.Pair[string, int]]]bool"},
+		},
+	} {
+		opts := Options{Dir: output, Package: "input", Instance: test.instance, Imports: test.imports}
+		result, err := New().Generate(context.Background(), opts)
+		if err != nil {
+			t.Errorf("%s: Generate failed: %v", test.instance, err)
+			continue
+		}
+		got := string(result.Files[0].Content)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: expecting %q in\n%s", test.instance, want, got)
+			}
+		}
+	}
+
+	// The constraints are checked against the instantiated type
+	_, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "FuncSet(box[func()])"})
+	var constraintErr *ConstraintError
+	if !errors.As(err, &constraintErr) {
+		t.Errorf("Expecting ConstraintError as box[func()] isn't comparable, got %v", err)
+	}
+
+	// Generics need go 1.18 in the destination module.  The go
+	// command would raise its go version to that of the template
+	// module, so lower that too.
+	writeFile(t, path.Join(input, "go.mod"), "module input\n\ngo 1.17\n")
+	writeFile(t, path.Join(output, "go.mod"), "module output\n\ngo 1.17\n\nrequire input v0.0.0\n\nreplace input => ../input\n")
+	_, err = New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "BoxSet(box[int])"})
+	if err == nil || !strings.Contains(err.Error(), "needs go1.18") {
+		t.Errorf("Expecting go version error, got %v", err)
+	}
+	_, err = New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "IntSet(int)"})
+	if err != nil {
+		t.Errorf("Generate failed: %v", err)
+	}
+}
+
+func TestModuleGoVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		gomod string
+		want  string
+	}{
+		{"module x\n\ngo 1.22\n", "go1.22"},
+		{"module x\n\ngo 1.21.3 // comment\n", "go1.21.3"},
+		{"module x\n", "go1.16"},
+	} {
+		writeFile(t, path.Join(dir, "go.mod"), test.gomod)
+		got, err := moduleGoVersion(path.Join(dir, "sub"))
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %q, %v want %q", test.gomod, got, err, test.want)
+		}
+	}
+}
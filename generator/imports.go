@@ -0,0 +1,207 @@
+// Resolves the packages of qualified arguments
+
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// parseImports parses the Imports option, a list of alias=path,
+// returning the paths by alias
+func parseImports(imports []string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, imp := range imports {
+		alias, importPath, found := strings.Cut(imp, "=")
+		alias, importPath = strings.TrimSpace(alias), strings.TrimSpace(importPath)
+		if !found || !token.IsIdentifier(alias) || importPath == "" {
+			return nil, fmt.Errorf("bad import %q - expecting alias=path", imp)
+		}
+		if other, found := aliases[alias]; found && other != importPath {
+			return nil, fmt.Errorf("import alias %s given for %s and %s", alias, other, importPath)
+		}
+		aliases[alias] = importPath
+	}
+	return aliases, nil
+}
+
+// quotedImports returns the import paths used by the argument arg as
+// in "github.com/acme/ids".UserID
+func quotedImports(arg string) (paths []string) {
+	x, err := parser.ParseExpr(arg)
+	if err != nil {
+		return nil
+	}
+	ast.Inspect(x, func(n ast.Node) bool {
+		if lit := quotedImport(n); lit != nil {
+			if importPath, err := strconv.Unquote(lit.Value); err == nil {
+				paths = append(paths, importPath)
+			}
+		}
+		return true
+	})
+	return paths
+}
+
+// quotedImport returns the import path of n if it is a selector like
+// "github.com/acme/ids".UserID or nil otherwise
+func quotedImport(n ast.Node) *ast.BasicLit {
+	sel, ok := n.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	lit, ok := sel.X.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return nil
+	}
+	return lit
+}
+
+// resolveImports finds the packages the arguments use by import path,
+// as in "github.com/acme/ids".UserID, or by an alias of the Imports
+// option, returning an ImportError if they can't be found from Dir.
+//
+// Packages given by path are named after the package unless an import
+// of the template or another argument has that name.  The arguments
+// are rewritten to use the names.
+func (t *template) resolveImports(pkg *typedPackage) error {
+	aliases, err := parseImports(t.opts.Imports)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, importPath := range aliases {
+		paths = append(paths, importPath)
+	}
+	for _, arg := range t.Args {
+		paths = append(paths, quotedImports(arg)...)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+	conf := &packages.Config{
+		Context: t.ctx,
+		Mode:    packages.NeedName,
+		Dir:     t.opts.Dir,
+	}
+	pkgs, err := packages.Load(conf, paths...)
+	if err != nil {
+		return &ImportError{Path: strings.Join(paths, ", "), Err: err}
+	}
+	names := map[string]string{}
+	for _, p := range pkgs {
+		if len(p.Errors) > 0 {
+			return &ImportError{Path: p.PkgPath, Err: p.Errors[0]}
+		}
+		names[p.PkgPath] = p.Name
+	}
+
+	// Work out the name each package is imported as
+	t.importNames = map[string]string{}
+	taken := templateImports(pkg)
+	for alias, importPath := range aliases {
+		if _, found := names[importPath]; !found {
+			return &ImportError{Path: importPath, Err: fmt.Errorf("not found")}
+		}
+		if other, found := taken[alias]; found && other != importPath {
+			return fmt.Errorf("import alias %s for %s is used by the template for %s", alias, importPath, other)
+		}
+		taken[alias] = importPath
+		t.importNames[importPath] = alias
+	}
+	for _, importPath := range paths {
+		if _, found := t.importNames[importPath]; found {
+			continue
+		}
+		name := names[importPath]
+		if name == "" {
+			return &ImportError{Path: importPath, Err: fmt.Errorf("not found")}
+		}
+		for n := 2; taken[name] != "" && taken[name] != importPath; n++ {
+			name = names[importPath] + strconv.Itoa(n)
+		}
+		taken[name] = importPath
+		t.importNames[importPath] = name
+	}
+
+	for param, arg := range t.templateArgsMap {
+		t.templateArgsMap[param] = t.qualify(arg)
+	}
+	t.debugf("importNames = %v", t.importNames)
+	return nil
+}
+
+// templateImports returns the paths of the imports of the template by
+// the names they are imported as
+func templateImports(pkg *typedPackage) map[string]string {
+	names := map[string]string{}
+	for _, imp := range pkg.types.Imports() {
+		names[imp.Path()] = imp.Name()
+	}
+	imports := map[string]string{}
+	for _, f := range pkg.files {
+		for _, spec := range f.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			name := names[importPath]
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports[name] = importPath
+		}
+	}
+	return imports
+}
+
+// qualify rewrites the import paths in arg, as in
+// "github.com/acme/ids".UserID, to the names they are imported as
+func (t *template) qualify(arg string) string {
+	if len(t.importNames) == 0 || !strings.Contains(arg, `"`) {
+		return arg
+	}
+	x, err := parser.ParseExpr(arg)
+	if err != nil {
+		return arg
+	}
+	ast.Inspect(x, func(n ast.Node) bool {
+		if lit := quotedImport(n); lit != nil {
+			if importPath, err := strconv.Unquote(lit.Value); err == nil && t.importNames[importPath] != "" {
+				n.(*ast.SelectorExpr).X = ast.NewIdent(t.importNames[importPath])
+			}
+		}
+		return true
+	})
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), x); err != nil {
+		return arg
+	}
+	return buf.String()
+}
+
+// addImports adds the imports of the arguments to files.  Those which
+// aren't used are removed when the imports are fixed.
+func (t *template) addImports(fset *token.FileSet, files []*ast.File) {
+	for _, f := range files {
+		for importPath, name := range t.importNames {
+			if name == path.Base(importPath) {
+				astutil.AddImport(fset, f, importPath)
+			} else {
+				astutil.AddNamedImport(fset, f, name, importPath)
+			}
+		}
+	}
+}
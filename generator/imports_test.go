@@ -0,0 +1,84 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const importsTemplate = `package tt
+
+import "strings"
+
+// template type Set(A)
+type A int
+
+type Set map[A]bool
+
+func (s Set) String() string { return strings.Repeat("x", len(s)) }
+`
+
+func TestImports(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": importsTemplate})
+	defer cleanup()
+	input := path.Join(path.Dir(output), "input")
+	for dir, src := range map[string]string{
+		"ids":  "package ids\n\ntype UserID int\n",
+		"strs": "package strings\n\ntype Name string\n",
+	} {
+		if err := os.Mkdir(path.Join(input, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, path.Join(input, dir, dir+".go"), src)
+	}
+
+	for _, test := range []struct {
+		instance string
+		imports  []string
+		want     []string
+	}{
+		{
+			instance: `UserSet("input/ids".UserID)`,
+			want:     []string{`"input/ids"`, "map[ids.UserID]bool", `"args":["\"input/ids\".UserID"]`},
+		},
+		{
+			// The name strings is taken by the template
+			instance: `NameSet("input/strs".Name)`,
+			want:     []string{`strings2 "input/strs"`, "map[strings2.Name]bool", `"strings"`},
+		},
+		{
+			instance: `USet(u.UserID)`,
+			imports:  []string{"u=input/ids"},
+			want:     []string{`u "input/ids"`, "map[u.UserID]bool", `"flags":["-import","u=input/ids"]`},
+		},
+	} {
+		opts := Options{Dir: output, Package: "input", Instance: test.instance, Imports: test.imports}
+		result, err := New().Generate(context.Background(), opts)
+		if err != nil {
+			t.Errorf("%s: Generate failed: %v", test.instance, err)
+			continue
+		}
+		got := string(result.Files[0].Content)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: expecting %q in\n%s", test.instance, want, got)
+			}
+		}
+	}
+
+	// Packages which can't be found
+	_, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: `XSet("input/missing".X)`})
+	var importErr *ImportError
+	if !errors.As(err, &importErr) || importErr.Path != "input/missing" {
+		t.Errorf("Expecting ImportError for input/missing, got %v", err)
+	}
+
+	// An alias the template uses for something else
+	_, err = New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "XSet(strings.UserID)", Imports: []string{"strings=input/ids"}})
+	if err == nil || !strings.Contains(err.Error(), "used by the template") {
+		t.Errorf("Expecting alias error, got %v", err)
+	}
+}
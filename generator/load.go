@@ -0,0 +1,250 @@
+// Loads and caches the template packages
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadedPackage is a template package as loaded by packages.Load
+//
+// Instantiating a template rewrites its syntax trees in place so each
+// instantiation type checks a fresh copy of the sources against the
+// already loaded imports.
+type loadedPackage struct {
+	pkg     *packages.Package
+	srcs    [][]byte // contents of pkg.CompiledGoFiles
+	hash    string   // hash of srcs
+	version string   // module version if known
+}
+
+// typedPackage is a freshly parsed and type checked copy of a template
+// package
+type typedPackage struct {
+	fset    *token.FileSet
+	files   []*ast.File
+	types   *types.Package
+	info    *types.Info
+	hash    string
+	version string
+}
+
+// moduleRoot finds the root of the module dir is in, which is the
+// directory of the nearest go.mod.  If there isn't one dir is returned.
+func moduleRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// isLocal returns true if pkgPath is a directory or a .go file rather
+// than an import path
+func isLocal(pkgPath string) bool {
+	return pkgPath == "." || pkgPath == ".." ||
+		strings.HasPrefix(pkgPath, "./") || strings.HasPrefix(pkgPath, "../") ||
+		filepath.IsAbs(pkgPath) || strings.HasSuffix(pkgPath, ".go")
+}
+
+// loaded returns the template package pkgPath as resolved from dir,
+// loading it if it isn't in the cache.  Packages are resolved the same
+// way from everywhere in a module so the module is the cache key,
+// unless the package is given as a path when the path is the key.
+func (g *Generator) loaded(ctx context.Context, dir, pkgPath string) (*loadedPackage, error) {
+	key := moduleRoot(dir) + "\x00" + pkgPath
+	if isLocal(pkgPath) {
+		key = filepath.Join(dir, pkgPath)
+	}
+	lp, ok := g.cache[key]
+	if !ok {
+		var err error
+		lp, err = loadPackage(ctx, dir, pkgPath)
+		if err != nil {
+			return nil, err
+		}
+		g.cache[key] = lp
+	}
+	return lp, nil
+}
+
+// load the template package pkgPath as resolved from dir returning a
+// fresh copy ready for instantiation
+func (g *Generator) load(ctx context.Context, dir, pkgPath string) (*typedPackage, error) {
+	lp, err := g.loaded(ctx, dir, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	return lp.check(pkgPath)
+}
+
+// loadPackage loads and type checks the template package pkgPath as
+// resolved from dir.  This uses the go command so it understands
+// modules, replace directives, workspaces and vendoring.
+//
+// pkgPath may also be a directory or a single .go file relative to dir.
+func loadPackage(ctx context.Context, dir, pkgPath string) (*loadedPackage, error) {
+	conf := &packages.Config{
+		Context: ctx,
+		Mode:    packages.LoadSyntax | packages.NeedModule,
+		Dir:     dir,
+	}
+
+	pkgs, err := packages.Load(conf, pkgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", pkgPath, err)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expecting 1 package for %q but found %d", pkgPath, len(pkgs))
+	}
+
+	pkg := pkgs[0]
+
+	if len(pkg.Errors) > 0 {
+		return nil, &TypeCheckError{Package: pkgPath, Errs: pkg.Errors}
+	}
+	if len(pkg.CompiledGoFiles) == 0 {
+		return nil, fmt.Errorf("no go files found for package '%s'", pkgPath)
+	}
+
+	lp := &loadedPackage{pkg: pkg}
+	for _, name := range pkg.CompiledGoFiles {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file: %w", err)
+		}
+		lp.srcs = append(lp.srcs, src)
+	}
+	lp.hash = hashSources(pkg.CompiledGoFiles, lp.srcs)
+	if m := pkg.Module; m != nil {
+		lp.version = m.Version
+		if m.Replace != nil {
+			lp.version = m.Replace.Version
+		}
+	}
+	return lp, nil
+}
+
+// findPackageName finds the name of the package in dir
+func findPackageName(ctx context.Context, dir string) (string, error) {
+	conf := &packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName,
+		Dir:     dir,
+	}
+	pkgs, err := packages.Load(conf, ".")
+	if err != nil {
+		return "", fmt.Errorf("failed to read package in %s: %w", dir, err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name == "" {
+		msg := "no go files"
+		if len(pkgs) == 1 && len(pkgs[0].Errors) > 0 {
+			msg = pkgs[0].Errors[0].Error()
+		}
+		return "", fmt.Errorf("failed to read package in %s: %s", dir, msg)
+	}
+	return pkgs[0].Name, nil
+}
+
+// outputPackageName finds the name of the package the output files
+// are written into.  This is the PackageName option if set, otherwise
+// the package already in OutDir, otherwise one made from the name of
+// OutDir if it is a new directory.
+func outputPackageName(ctx context.Context, opts Options) (string, error) {
+	if hasGoFiles(opts.OutDir) {
+		name, err := findPackageName(ctx, opts.OutDir)
+		if err != nil {
+			return "", err
+		}
+		if opts.PackageName != "" && opts.PackageName != name {
+			return "", fmt.Errorf("package %s requested but %s has package %s", opts.PackageName, opts.OutDir, name)
+		}
+		return name, nil
+	}
+	if opts.PackageName != "" {
+		if !token.IsIdentifier(opts.PackageName) {
+			return "", fmt.Errorf("bad package name %q", opts.PackageName)
+		}
+		return opts.PackageName, nil
+	}
+	if opts.OutDir == opts.Dir {
+		return findPackageName(ctx, opts.Dir)
+	}
+	name := strings.ToLower(strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return -1
+		}
+		return r
+	}, filepath.Base(opts.OutDir)))
+	if !token.IsIdentifier(name) {
+		return "", fmt.Errorf("can't make a package name from %s - use the package name option", opts.OutDir)
+	}
+	return name, nil
+}
+
+// hasGoFiles returns true if dir has any .go files in
+func hasGoFiles(dir string) bool {
+	names, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	return len(names) > 0
+}
+
+// check parses and type checks a fresh copy of the package
+func (lp *loadedPackage) check(pkgPath string) (*typedPackage, error) {
+	tp := &typedPackage{
+		hash:    lp.hash,
+		version: lp.version,
+		fset:    token.NewFileSet(),
+		info: &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Instances:  make(map[*ast.Ident]types.Instance),
+			Implicits:  make(map[ast.Node]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+			Scopes:     make(map[ast.Node]*types.Scope),
+		},
+	}
+	for i, name := range lp.pkg.CompiledGoFiles {
+		f, err := parser.ParseFile(tp.fset, name, lp.srcs[i], parser.ParseComments)
+		if err != nil {
+			return nil, &TypeCheckError{Package: pkgPath, Errs: []packages.Error{{Msg: err.Error()}}}
+		}
+		tp.files = append(tp.files, f)
+	}
+	conf := &types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			imp, ok := lp.pkg.Imports[path]
+			if !ok || imp.Types == nil {
+				return nil, fmt.Errorf("package %q not loaded", path)
+			}
+			return imp.Types, nil
+		}),
+		Sizes: lp.pkg.TypesSizes,
+	}
+	var err error
+	tp.types, err = conf.Check(lp.pkg.PkgPath, tp.fset, tp.files, tp.info)
+	if err != nil {
+		return nil, &TypeCheckError{Package: pkgPath, Errs: []packages.Error{{Msg: err.Error()}}}
+	}
+	return tp, nil
+}
+
+// importerFunc implements types.Importer
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
@@ -0,0 +1,108 @@
+// Reads the manifest listing instantiations to generate in one run
+
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Manifest lists template instantiations to be generated together
+//
+// It is read from a JSON file like this
+//
+//	{
+//	    "instances": [
+//	        {
+//	            "package": "github.com/sandwich-go/gotemplate/set",
+//	            "name": "StringSet",
+//	            "args": ["string"],
+//	            "dir": "internal/collections"
+//	        }
+//	    ]
+//	}
+type Manifest struct {
+	Instances []ManifestEntry `json:"instances"`
+
+	// Dir is the directory relative directories in the entries
+	// are relative to - the directory of the manifest file
+	Dir string `json:"-"`
+}
+
+// ManifestEntry is a single instantiation in a Manifest
+type ManifestEntry struct {
+	Package      string   `json:"package"`                // import path of the template package
+	Name         string   `json:"name"`                   // instance name, eg "MySet"
+	Definition   string   `json:"definition,omitempty"`   // template chosen if the package defines several
+	Args         []string `json:"args"`                   // template arguments, eg ["string"]
+	Dir          string   `json:"dir,omitempty"`          // destination package directory
+	PkgName      string   `json:"pkg,omitempty"`          // as the -pkg flag
+	OutFmt       string   `json:"outfmt,omitempty"`       // as the -outfmt flag
+	RawName      bool     `json:"r,omitempty"`            // as the -r flag
+	Test         bool     `json:"t,omitempty"`            // as the -t flag
+	Split        bool     `json:"split,omitempty"`        // as the -split flag
+	Keep         []string `json:"keep,omitempty"`         // as the -keep flag
+	Prune        bool     `json:"prune,omitempty"`        // as the -prune flag
+	Disambiguate bool     `json:"disambiguate,omitempty"` // as the -disambiguate flag
+	Alias        bool     `json:"alias,omitempty"`        // as the -alias flag
+	Imports      []string `json:"imports,omitempty"`      // as the -import flag
+	Generic      string   `json:"generic,omitempty"`      // as the -generic flag
+}
+
+// ReadManifest reads the manifest in the file path
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %q: %w", path, err)
+	}
+	m.Dir = filepath.Dir(path)
+	for i, e := range m.Instances {
+		if e.Package == "" || e.Name == "" {
+			return nil, fmt.Errorf("manifest %q: instance %d needs a package and a name", path, i)
+		}
+	}
+	return m, nil
+}
+
+// Instance returns the instantiation string, eg "MySet(string)"
+func (e *ManifestEntry) Instance() string {
+	if e.Definition != "" {
+		return e.Name + " = " + e.Definition + "(" + strings.Join(e.Args, ", ") + ")"
+	}
+	return e.Name + "(" + strings.Join(e.Args, ", ") + ")"
+}
+
+// Options returns the Options for each instance in the manifest
+func (m *Manifest) Options() []Options {
+	var opts []Options
+	for _, e := range m.Instances {
+		dir := e.Dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(m.Dir, dir)
+		}
+		opts = append(opts, Options{
+			Dir:          dir,
+			Package:      e.Package,
+			Instance:     e.Instance(),
+			PackageName:  e.PkgName,
+			OutFmt:       e.OutFmt,
+			RawName:      e.RawName,
+			Test:         e.Test,
+			Split:        e.Split,
+			Keep:         e.Keep,
+			Prune:        e.Prune,
+			Disambiguate: e.Disambiguate,
+			Alias:        e.Alias,
+			Imports:      e.Imports,
+			Generic:      e.Generic,
+		})
+	}
+	return opts
+}
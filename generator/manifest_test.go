@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const manifestTemplate = `package tt
+
+// template type Set(A)
+type A int
+
+type Set map[A]struct{}
+`
+
+func TestManifest(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": manifestTemplate})
+	defer cleanup()
+
+	manifest := path.Join(output, "gotemplate.json")
+	err := ioutil.WriteFile(manifest, []byte(`{
+	"instances": [
+		{"package": "input", "name": "IntSet", "args": ["int"], "r": true},
+		{"package": "input", "name": "stringSet", "args": ["string"], "outfmt": "gen_%v"}
+	]
+}`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write %q: %v", manifest, err)
+	}
+
+	m, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatalf("ReadManifest failed: %v", err)
+	}
+	if got := m.Instances[1].Instance(); got != "stringSet(string)" {
+		t.Errorf("Instance() = %q", got)
+	}
+
+	g := New()
+	for _, opts := range m.Options() {
+		if _, err := g.Instantiate(context.Background(), opts); err != nil {
+			t.Fatalf("Instantiate %s failed: %v", opts.Instance, err)
+		}
+	}
+	if len(g.cache) != 1 {
+		t.Errorf("template package loaded %d times, expecting 1", len(g.cache))
+	}
+
+	checkOutput(t, path.Join(output, "gotemplate_IntSet.go"), `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type IntSet map[int]struct{}
+`)
+	checkOutput(t, path.Join(output, "gen_string_set.go"), `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type stringSet map[string]struct{}
+`)
+}
+
+func TestManifestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotemplate_test")
+	if err != nil {
+		t.Fatalf("Failed to make temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, in := range []string{
+		`{"instances": [`,
+		`{"instances": [{"name": "MySet"}]}`,
+	} {
+		manifest := path.Join(dir, "gotemplate.json")
+		err = ioutil.WriteFile(manifest, []byte(in), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write %q: %v", manifest, err)
+		}
+		if _, err := ReadManifest(manifest); err == nil {
+			t.Errorf("%s: expecting error", in)
+		}
+	}
+}
@@ -0,0 +1,1094 @@
+// Converts template packages into generic packages
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"go/version"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/imports"
+)
+
+// orderedVersion is the first go version with the cmp package
+const orderedVersion = "go1.21"
+
+// MigrateOptions control converting a template package into a generic
+// package
+type MigrateOptions struct {
+	// Dir is the directory the template package is resolved from.
+	// It defaults to the current directory.
+	Dir string
+
+	// Package is the import path of the template package
+	Package string
+
+	// OutDir is the directory the generic package is written to,
+	// relative to Dir if not absolute. It defaults to Dir.
+	OutDir string
+
+	// Force makes Migrate write the generic package even if some of
+	// the template couldn't be converted
+	Force bool
+
+	// Verbose sends debugging output to Logf
+	Verbose bool
+
+	// Logf receives debugging output if Verbose is set. It may be nil.
+	Logf func(format string, args ...interface{})
+}
+
+// Unconverted is a construct of a template package which couldn't be
+// converted to generics
+type Unconverted struct {
+	Pos    token.Position // where it is, in the output for type errors
+	Reason string         // why it couldn't be converted
+}
+
+func (u Unconverted) String() string {
+	return fmt.Sprintf("%v: %s", u.Pos, u.Reason)
+}
+
+// MigrateResult describes the outcome of a migration
+type MigrateResult struct {
+	Result
+	Unconverted []Unconverted
+}
+
+// migration is a template package being converted to generics
+type migration struct {
+	t           *template
+	lp          *loadedPackage
+	pkg         *typedPackage
+	params      []string                   // the parameters of all the templates
+	typeParams  map[string]*types.TypeName // the stub types by parameter
+	valueParams map[string]types.Object    // the stub funcs, consts and vars converted
+	methodFuncs map[types.Object]string    // the stub funcs made methods, with their type parameters
+	stubSpecs   map[string]*ast.TypeSpec   // the declarations of the stub types
+	constraints map[string]*constraint
+	stubMethods map[string][]*ast.FuncDecl // the methods of the stub types
+	dropped     map[ast.Node]bool
+	units       []*migrateUnit
+	usesCmp     bool
+
+	// where identifiers are used
+	callFun  map[*ast.Ident]bool
+	litType  map[*ast.Ident]*ast.CompositeLit
+	newArg   map[*ast.Ident]bool
+	constUse map[*ast.Ident]string
+
+	typeNeeds  map[types.Object]map[string]bool // type parameters needed
+	valueNeeds map[types.Object]map[string]bool // value parameters needed
+
+	unconverted []Unconverted
+}
+
+// migrateUnit is a function, method or single spec of a declaration
+type migrateUnit struct {
+	node   ast.Node        // the FuncDecl or Spec
+	obj    types.Object    // what it declares, the receiver type for methods
+	method bool            // if it is a method
+	uses   []*ast.Ident    // the identifiers using package level objects
+	value  bool            // if it is a const or var spec
+	strct  *ast.StructType // the struct if it declares one
+}
+
+// GenerateMigration converts the template package described by opts
+// into a generic package but doesn't write anything.
+//
+// The stub types of the template parameters become type parameters
+// constrained by their "// template constraint" comments and the stub
+// funcs, consts and vars become parameters of the functions which use
+// them or fields of the structs whose methods use them.  Stub funcs
+// the methods of other types need become methods of the constraint of
+// their first parameter's type parameter instead.  What can't be
+// converted is listed in the Unconverted of the result, including any
+// errors type checking the generic package.
+func (g *Generator) GenerateMigration(ctx context.Context, opts MigrateOptions) (MigrateResult, error) {
+	var result MigrateResult
+	o := Options{Dir: opts.Dir, Package: opts.Package, OutDir: opts.OutDir, Verbose: opts.Verbose, Logf: opts.Logf}
+	if err := o.setDefaults(); err != nil {
+		return result, err
+	}
+	lp, err := g.loaded(ctx, o.Dir, o.Package)
+	if err != nil {
+		return result, err
+	}
+	pkg, err := lp.check(o.Package)
+	if err != nil {
+		return result, err
+	}
+	m := &migration{
+		t:           &template{g: g, ctx: ctx, opts: o, Package: o.Package, Dir: o.Dir},
+		lp:          lp,
+		pkg:         pkg,
+		typeParams:  map[string]*types.TypeName{},
+		valueParams: map[string]types.Object{},
+		methodFuncs: map[types.Object]string{},
+		stubSpecs:   map[string]*ast.TypeSpec{},
+		constraints: map[string]*constraint{},
+		stubMethods: map[string][]*ast.FuncDecl{},
+		dropped:     map[ast.Node]bool{},
+	}
+	if err := m.findParams(); err != nil {
+		return result, err
+	}
+	m.findUses()
+	m.analyse()
+	if err := m.rewrite(); err != nil {
+		return result, err
+	}
+	files, err := m.render(o.OutDir)
+	if err != nil {
+		return result, err
+	}
+	m.check(files)
+	m.checkGoVersion(o.OutDir)
+	for _, f := range files {
+		curr, err := readExisting(f.Path)
+		if err != nil {
+			return result, err
+		}
+		f.Previous = curr
+		f.Changed = !bytes.Equal(curr, f.Content)
+		result.Files = append(result.Files, f)
+	}
+	sort.SliceStable(m.unconverted, func(i, j int) bool {
+		a, b := m.unconverted[i].Pos, m.unconverted[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+	result.Unconverted = m.unconverted
+	return result, nil
+}
+
+// Migrate converts the template package described by opts into a
+// generic package as GenerateMigration does and writes the files which
+// have changed.  Nothing is written if anything couldn't be converted
+// unless opts.Force is set.
+func (g *Generator) Migrate(ctx context.Context, opts MigrateOptions) (MigrateResult, error) {
+	result, err := g.GenerateMigration(ctx, opts)
+	if err != nil || len(result.Unconverted) > 0 && !opts.Force {
+		return result, err
+	}
+	o := Options{Verbose: opts.Verbose, Logf: opts.Logf}
+	return result, writeFiles(result.Files, o.debugf)
+}
+
+// report records that the construct at pos couldn't be converted
+func (m *migration) report(pos token.Pos, format string, args ...interface{}) {
+	m.unconverted = append(m.unconverted, Unconverted{Pos: m.pkg.fset.Position(pos), Reason: fmt.Sprintf(format, args...)})
+}
+
+// findParams finds the parameters of all the templates in the package
+// and sorts them into type and value parameters
+func (m *migration) findParams() error {
+	t, pkg := m.t, m.pkg
+	allParams := map[string]bool{}
+	for _, f := range pkg.files {
+		for _, cg := range f.Comments {
+			for _, x := range cg.List {
+				matches := matchTemplateType.FindStringSubmatch(x.Text)
+				if matches == nil {
+					continue
+				}
+				name, args, names, err := t.parseTemplateAndArgs(matches[1])
+				if err != nil {
+					return err
+				}
+				t.templateNames = append(t.templateNames, name)
+				params, _ := templateParams(args, names)
+				for _, param := range params {
+					if !allParams[param] {
+						allParams[param] = true
+						m.params = append(m.params, param)
+					}
+				}
+			}
+		}
+	}
+	if len(t.templateNames) == 0 {
+		return &MissingDefinitionError{Package: t.Package}
+	}
+	t.templateArgs = m.params
+	constraints, err := t.findConstraints(pkg.files, allParams)
+	if err != nil {
+		return err
+	}
+	for _, c := range constraints {
+		m.constraints[c.param] = c
+	}
+
+	scope := pkg.types.Scope()
+	for _, param := range m.params {
+		switch obj := scope.Lookup(param).(type) {
+		case *types.TypeName:
+			m.typeParams[param] = obj
+		case *types.Func, *types.Const, *types.Var:
+			m.valueParams[param] = obj
+		default:
+			m.report(token.NoPos, "template parameter %s has no declaration to convert", param)
+		}
+	}
+	t.debugf("type parameters %v, value parameters %v", m.typeParams, m.valueParams)
+
+	// Drop the stub types with their methods and the format funcs
+	for _, f := range pkg.files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					continue
+				}
+				if recv := recvTypeName(pkg.info, d); recv != nil && m.typeParams[recv.Name()] == recv {
+					m.stubMethods[recv.Name()] = append(m.stubMethods[recv.Name()], d)
+					m.dropped[d] = true
+				}
+			case *ast.GenDecl:
+				if isFormatDecl(d) {
+					for _, spec := range d.Specs {
+						m.report(spec.Pos(), "format funcs can't be converted")
+						m.dropped[spec] = true
+					}
+					continue
+				}
+				for _, spec := range d.Specs {
+					if s, ok := spec.(*ast.TypeSpec); ok && m.typeParams[s.Name.Name] == pkg.info.Defs[s.Name] {
+						m.stubSpecs[s.Name.Name] = s
+						m.dropped[s] = true
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isFormatDecl returns true if d is marked with "//template format"
+func isFormatDecl(d *ast.GenDecl) bool {
+	if d.Doc == nil {
+		return false
+	}
+	for _, x := range d.Doc.List {
+		if matchFormat.MatchString(x.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+// findUses finds the units of the package, the package level objects
+// they use and the context of those uses
+func (m *migration) findUses() {
+	info, scope := m.pkg.info, m.pkg.types.Scope()
+	m.callFun = map[*ast.Ident]bool{}
+	m.litType = map[*ast.Ident]*ast.CompositeLit{}
+	m.newArg = map[*ast.Ident]bool{}
+	m.constUse = map[*ast.Ident]string{}
+	markConst := func(n ast.Node, reason string) {
+		ast.Inspect(n, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				m.constUse[id] = reason
+			}
+			return true
+		})
+	}
+	for _, f := range m.pkg.files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.CallExpr:
+				if id, ok := x.Fun.(*ast.Ident); ok {
+					m.callFun[id] = true
+					if b, ok := info.Uses[id].(*types.Builtin); ok && b.Name() == "new" && len(x.Args) == 1 {
+						if arg, ok := x.Args[0].(*ast.Ident); ok {
+							m.newArg[arg] = true
+						}
+					}
+				}
+			case *ast.CompositeLit:
+				if id, ok := x.Type.(*ast.Ident); ok {
+					m.litType[id] = x
+				}
+			case *ast.ArrayType:
+				if x.Len != nil {
+					markConst(x.Len, "an array length")
+				}
+			case *ast.GenDecl:
+				if x.Tok == token.CONST {
+					markConst(x, "a constant")
+				}
+			}
+			return true
+		})
+
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if m.dropped[d] {
+					continue
+				}
+				u := &migrateUnit{node: d, obj: info.Defs[d.Name]}
+				if d.Recv != nil {
+					u.obj, u.method = recvTypeName(info, d), true
+				}
+				m.units = append(m.units, u)
+			case *ast.GenDecl:
+				if d.Tok == token.IMPORT {
+					continue
+				}
+				for _, spec := range d.Specs {
+					if m.dropped[spec] {
+						continue
+					}
+					u := &migrateUnit{node: spec}
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						u.obj = info.Defs[s.Name]
+						u.strct, _ = s.Type.(*ast.StructType)
+					case *ast.ValueSpec:
+						u.value = true
+						for _, name := range s.Names {
+							if obj := info.Defs[name]; obj != nil && u.obj == nil {
+								u.obj = obj
+							}
+						}
+					}
+					m.units = append(m.units, u)
+				}
+			}
+		}
+	}
+	for _, u := range m.units {
+		ast.Inspect(u.node, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if obj := info.Uses[id]; obj != nil && obj.Parent() == scope {
+					u.uses = append(u.uses, id)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// valueParam returns the value parameter obj is the stub of or ""
+func (m *migration) valueParam(obj types.Object) string {
+	for _, param := range m.params {
+		if m.valueParams[param] == obj && obj != nil {
+			return param
+		}
+	}
+	return ""
+}
+
+// isStub returns true if u declares a converted value parameter
+func (m *migration) isStub(u *migrateUnit) bool {
+	return !u.method && (m.valueParam(u.obj) != "" || m.methodFuncs[u.obj] != "")
+}
+
+// methodTypeParam returns the type parameter whose constraint the stub
+// func obj can become a method of, or "" if it can't.  That is the
+// type parameter of its first parameter, which must not have a method
+// of the same name already, and obj must only be called.
+func (m *migration) methodTypeParam(obj types.Object) string {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return ""
+	}
+	params := fn.Type().(*types.Signature).Params()
+	if params.Len() == 0 {
+		return ""
+	}
+	named, ok := params.At(0).Type().(*types.Named)
+	if !ok || m.typeParams[named.Obj().Name()] != named.Obj() {
+		return ""
+	}
+	param := named.Obj().Name()
+	for _, d := range m.stubMethods[param] {
+		if d.Name.Name == fn.Name() {
+			return ""
+		}
+	}
+	for id, used := range m.pkg.info.Uses {
+		if used == obj && !m.callFun[id] {
+			return ""
+		}
+	}
+	return param
+}
+
+// addNeed adds param to the needs of obj, returning true if it is new
+func addNeed(needs map[types.Object]map[string]bool, obj types.Object, param string) bool {
+	if needs[obj] == nil {
+		needs[obj] = map[string]bool{}
+	}
+	if needs[obj][param] {
+		return false
+	}
+	needs[obj][param] = true
+	return true
+}
+
+// analyse works out which value parameters can be converted and the
+// parameters each declaration needs
+func (m *migration) analyse() {
+	for {
+		m.findValueNeeds()
+		infeasible, methods := m.checkValueNeeds()
+		if len(infeasible) == 0 && len(methods) == 0 {
+			break
+		}
+		for _, param := range infeasible {
+			m.t.debugf("Keeping the stub of %s", param)
+			delete(m.valueParams, param)
+		}
+		for _, param := range methods {
+			obj := m.valueParams[param]
+			m.methodFuncs[obj] = m.methodTypeParam(obj)
+			m.report(obj.Pos(), "%s becomes a method of the constraint of %s so the arguments of %s must have it rather than a function being passed", param, m.methodFuncs[obj], m.methodFuncs[obj])
+			delete(m.valueParams, param)
+		}
+	}
+	for _, param := range m.params {
+		if obj := m.valueParams[param]; obj != nil {
+			m.dropped[m.stubNode(obj)] = true
+		}
+	}
+	for obj := range m.methodFuncs {
+		m.dropped[m.stubNode(obj)] = true
+	}
+	m.findTypeNeeds()
+}
+
+// stubNode returns the unit node declaring the stub obj
+func (m *migration) stubNode(obj types.Object) ast.Node {
+	for _, u := range m.units {
+		if !u.method && u.obj == obj {
+			return u.node
+		}
+	}
+	return nil
+}
+
+// findValueNeeds works out the value parameters each function needs
+// passed and each struct needs as fields.  Functions need those they
+// use, those of the functions they call and those of the structs they
+// make.  Structs need those their methods need.
+func (m *migration) findValueNeeds() {
+	info := m.pkg.info
+	m.valueNeeds = map[types.Object]map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, u := range m.units {
+			if u.obj == nil || m.isStub(u) {
+				continue
+			}
+			for _, id := range u.uses {
+				obj := info.Uses[id]
+				if param := m.valueParam(obj); param != "" {
+					changed = addNeed(m.valueNeeds, u.obj, param) || changed
+					continue
+				}
+				if isTypeSpec(u.node) {
+					continue
+				}
+				if _, isType := obj.(*types.TypeName); isType && m.litType[id] == nil && !m.newArg[id] {
+					continue
+				}
+				for param := range m.valueNeeds[obj] {
+					changed = addNeed(m.valueNeeds, u.obj, param) || changed
+				}
+			}
+		}
+	}
+}
+
+// checkValueNeeds returns the value parameters which can't be
+// converted, reporting why, and the stub funcs which can't be passed
+// but can be made methods
+func (m *migration) checkValueNeeds() (infeasible, methods []string) {
+	info := m.pkg.info
+	bad := map[string]bool{}
+	fail := func(param string, pos token.Pos, format string, args ...interface{}) {
+		if !bad[param] {
+			bad[param] = true
+			infeasible = append(infeasible, param)
+			m.report(pos, "%s can't be converted: %s", param, fmt.Sprintf(format, args...))
+		}
+	}
+	asMethod := map[string]bool{}
+	for _, u := range m.units {
+		if u.obj == nil || m.isStub(u) {
+			continue
+		}
+		for _, id := range u.uses {
+			obj := info.Uses[id]
+			if param := m.valueParam(obj); param != "" {
+				if reason := m.constUse[id]; reason != "" {
+					fail(param, id.Pos(), "it is used as %s", reason)
+				}
+				continue
+			}
+			for _, param := range m.sortedNeeds(m.valueNeeds, obj) {
+				switch obj.(type) {
+				case *types.Func:
+					if !m.callFun[id] {
+						fail(param, id.Pos(), "%s needs it passing so can't be used as a value", id.Name)
+					}
+				case *types.TypeName:
+					if m.newArg[id] {
+						fail(param, id.Pos(), "new(%s) can't set it", id.Name)
+					} else if lit := m.litType[id]; lit != nil && len(lit.Elts) > 0 {
+						if _, keyed := lit.Elts[0].(*ast.KeyValueExpr); !keyed {
+							fail(param, id.Pos(), "the %s literal doesn't use field names", id.Name)
+						}
+					}
+				}
+			}
+		}
+		for _, param := range m.sortedNeeds(m.valueNeeds, u.obj) {
+			switch {
+			case u.value:
+				fail(param, u.node.Pos(), "%s is package level but would need it", u.obj.Name())
+			case u.obj.Name() == "init" && !u.method:
+				fail(param, u.node.Pos(), "init would need it")
+			case u.method:
+			case u.strct == nil && isTypeSpec(u.node):
+				if m.methodTypeParam(m.valueParams[param]) != "" {
+					asMethod[param] = true
+					break
+				}
+				fail(param, u.node.Pos(), "the methods of %s need it but %s isn't a struct to hold it", u.obj.Name(), u.obj.Name())
+			case u.strct != nil:
+				if field, _, _ := types.LookupFieldOrMethod(u.obj.Type(), true, m.pkg.types, lowerFirst(param)); field != nil {
+					fail(param, u.node.Pos(), "%s already has %s", u.obj.Name(), lowerFirst(param))
+				}
+			}
+		}
+	}
+	for _, param := range m.params {
+		if asMethod[param] && !bad[param] {
+			methods = append(methods, param)
+		}
+	}
+	return infeasible, methods
+}
+
+// isTypeSpec returns true if n is a type spec
+func isTypeSpec(n ast.Node) bool {
+	_, ok := n.(*ast.TypeSpec)
+	return ok
+}
+
+// sortedNeeds returns the parameters obj needs in template order
+func (m *migration) sortedNeeds(needs map[types.Object]map[string]bool, obj types.Object) (params []string) {
+	for _, param := range m.params {
+		if needs[obj][param] {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// findTypeNeeds works out the type parameters each type and function
+// needs, which are those they use and those of the generic types and
+// functions they use.  Types need those of their methods and those
+// things which get value parameters need the type parameters of them.
+func (m *migration) findTypeNeeds() {
+	info := m.pkg.info
+	valueTypeParams := map[string][]string{}
+	for _, param := range m.params {
+		if obj := m.valueParams[param]; obj != nil {
+			valueTypeParams[param] = m.typeParamsIn(obj.Type())
+		}
+	}
+	m.typeNeeds = map[types.Object]map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, u := range m.units {
+			if u.obj == nil || m.dropped[u.node] {
+				continue
+			}
+			for _, id := range u.uses {
+				obj := info.Uses[id]
+				if tn, ok := obj.(*types.TypeName); ok && m.typeParams[tn.Name()] == tn {
+					changed = addNeed(m.typeNeeds, u.obj, tn.Name()) || changed
+					continue
+				}
+				if param := m.methodFuncs[obj]; param != "" {
+					changed = addNeed(m.typeNeeds, u.obj, param) || changed
+					continue
+				}
+				for param := range m.typeNeeds[obj] {
+					changed = addNeed(m.typeNeeds, u.obj, param) || changed
+				}
+			}
+			for param := range m.valueNeeds[u.obj] {
+				for _, typeParam := range valueTypeParams[param] {
+					changed = addNeed(m.typeNeeds, u.obj, typeParam) || changed
+				}
+			}
+		}
+	}
+	for _, u := range m.units {
+		if u.obj == nil || m.dropped[u.node] || len(m.typeNeeds[u.obj]) == 0 {
+			continue
+		}
+		params := strings.Join(m.sortedNeeds(m.typeNeeds, u.obj), ", ")
+		if u.value {
+			m.report(u.node.Pos(), "%s is package level but uses type parameter %s", u.obj.Name(), params)
+		} else if u.obj.Name() == "init" && !u.method {
+			m.report(u.node.Pos(), "init uses type parameter %s", params)
+		}
+	}
+}
+
+// typeParamsIn returns the type parameters typ uses
+func (m *migration) typeParamsIn(typ types.Type) (params []string) {
+	found := map[string]bool{}
+	seen := map[types.Type]bool{}
+	var walk func(typ types.Type)
+	walk = func(typ types.Type) {
+		if typ == nil || seen[typ] {
+			return
+		}
+		seen[typ] = true
+		switch t := typ.(type) {
+		case *types.Named:
+			if m.typeParams[t.Obj().Name()] == t.Obj() {
+				found[t.Obj().Name()] = true
+			}
+		case *types.Pointer:
+			walk(t.Elem())
+		case *types.Slice:
+			walk(t.Elem())
+		case *types.Array:
+			walk(t.Elem())
+		case *types.Chan:
+			walk(t.Elem())
+		case *types.Map:
+			walk(t.Key())
+			walk(t.Elem())
+		case *types.Signature:
+			walk(t.Params())
+			walk(t.Results())
+		case *types.Tuple:
+			for i := 0; i < t.Len(); i++ {
+				walk(t.At(i).Type())
+			}
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				walk(t.Field(i).Type())
+			}
+		}
+	}
+	walk(typ)
+	for _, param := range m.params {
+		if found[param] {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// lowerFirst makes the first letter of name lower case
+func lowerFirst(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// usesName returns true if any identifier in n other than those
+// referring to except is called name
+func (m *migration) usesName(n ast.Node, name string, except types.Object) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			if obj := m.pkg.info.Uses[id]; obj == nil || obj != except {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// freeName returns name, numbered if needed so it isn't used in n
+func (m *migration) freeName(n ast.Node, name string, except types.Object) string {
+	free := name
+	for i := 2; m.usesName(n, free, except); i++ {
+		free = fmt.Sprintf("%s%d", name, i)
+	}
+	return free
+}
+
+// qualifier names the packages in types written in the template
+func (m *migration) qualifier(p *types.Package) string {
+	if p == m.pkg.types {
+		return ""
+	}
+	return p.Name()
+}
+
+// valueType returns the type of the value parameter param
+func (m *migration) valueType(param string) ast.Expr {
+	typ := m.valueParams[param].Type()
+	if _, isConst := m.valueParams[param].(*types.Const); isConst {
+		typ = types.Default(typ)
+	}
+	return parseExprNoPos(types.TypeString(typ, m.qualifier))
+}
+
+// parseExprNoPos parses the expression src which must be valid,
+// clearing its positions
+func parseExprNoPos(src string) ast.Expr {
+	x, err := parser.ParseExpr(src)
+	if err != nil {
+		panic(fmt.Sprintf("bad expression %q: %v", src, err))
+	}
+	clearPos(x)
+	return x
+}
+
+// constraintOf returns the constraint of the type parameter param
+func (m *migration) constraintOf(param string) ast.Expr {
+	base := "any"
+	if c := m.constraints[param]; c != nil {
+		switch c.kind {
+		case "comparable":
+			base = "comparable"
+		case "ordered":
+			base = "cmp.Ordered"
+			m.usesCmp = true
+		case "implements":
+			if err := m.checkInterface(c); err != nil {
+				m.report(c.pos, "constraint %s on type parameter %s can't be converted: %v", c, param, err)
+			} else {
+				base = c.typ
+			}
+		default:
+			m.report(c.pos, "constraint %s on type parameter %s can't be converted", c, param)
+		}
+	} else if spec := m.stubSpecs[param]; spec != nil && types.IsInterface(m.typeParams[param].Type()) {
+		// Arguments must still have the methods used
+		if iface := m.typeParams[param].Type().Underlying().(*types.Interface); !iface.Empty() {
+			base = types.ExprString(spec.Type)
+		}
+	}
+	var methods []string
+	for _, d := range m.stubMethods[param] {
+		if _, isPtr := d.Recv.List[0].Type.(*ast.StarExpr); isPtr {
+			m.report(d.Pos(), "method %s of %s has a pointer receiver so can't be required by its constraint", d.Name.Name, param)
+			continue
+		}
+		m.report(d.Pos(), "the body of method %s of %s is dropped so its arguments must have the method", d.Name.Name, param)
+		methods = append(methods, d.Name.Name+strings.TrimPrefix(types.ExprString(d.Type), "func"))
+	}
+	for _, name := range m.params {
+		obj := m.pkg.types.Scope().Lookup(name)
+		if obj == nil || m.methodFuncs[obj] != param {
+			continue
+		}
+		// The first parameter becomes the receiver
+		sig := obj.Type().(*types.Signature)
+		var vars []*types.Var
+		for i := 1; i < sig.Params().Len(); i++ {
+			vars = append(vars, sig.Params().At(i))
+		}
+		method := types.NewSignatureType(nil, nil, nil, types.NewTuple(vars...), sig.Results(), sig.Variadic())
+		methods = append(methods, name+strings.TrimPrefix(types.TypeString(method, m.qualifier), "func"))
+	}
+	if len(methods) == 0 {
+		return parseExprNoPos(base)
+	}
+	if base != "any" {
+		methods = append([]string{base}, methods...)
+	}
+	return parseExprNoPos("interface{ " + strings.Join(methods, "; ") + " }")
+}
+
+// checkInterface checks the type of the implements constraint c is an
+// interface in the template package
+func (m *migration) checkInterface(c *constraint) error {
+	expr, err := parser.ParseExpr(c.typ)
+	if err != nil {
+		return err
+	}
+	info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+	if err := types.CheckExpr(m.pkg.fset, m.pkg.types, c.pos, expr, info); err != nil {
+		return err
+	}
+	if tv := info.Types[expr]; !tv.IsType() || !types.IsInterface(tv.Type) {
+		return fmt.Errorf("not an interface")
+	}
+	return nil
+}
+
+// rewrite converts the syntax trees into the generic package
+func (m *migration) rewrite() error {
+	info := m.pkg.info
+	constraints := map[string]ast.Expr{}
+	for _, param := range m.params {
+		if m.typeParams[param] != nil {
+			constraints[param] = m.constraintOf(param)
+		}
+	}
+	typeParamList := func(obj types.Object) *ast.FieldList {
+		params := m.sortedNeeds(m.typeNeeds, obj)
+		if len(params) == 0 {
+			return nil
+		}
+		list := &ast.FieldList{}
+		for _, param := range params {
+			typ := constraints[param]
+			if iface, ok := typ.(*ast.InterfaceType); ok {
+				// Positioned at obj so it is printed on one line
+				oneLine, methods := *iface, *iface.Methods
+				oneLine.Interface, methods.Opening, methods.Closing = obj.Pos(), obj.Pos(), obj.Pos()
+				oneLine.Methods = &methods
+				typ = &oneLine
+			}
+			list.List = append(list.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(param)}, Type: typ})
+		}
+		return list
+	}
+
+	// Call the stub funcs made methods on their first arguments
+	for _, f := range m.pkg.files {
+		astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+			call, ok := c.Node().(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			id, ok := call.Fun.(*ast.Ident)
+			if !ok || m.methodFuncs[info.Uses[id]] == "" {
+				return true
+			}
+			sel := &ast.SelectorExpr{X: call.Args[0], Sel: ast.NewIdent(id.Name)}
+			if needsParens(sel, "X", sel.X) {
+				sel.X = &ast.ParenExpr{X: sel.X}
+			}
+			call.Fun, call.Args = sel, call.Args[1:]
+			return true
+		})
+	}
+
+	// Pass the value parameters
+	for _, u := range m.units {
+		if u.obj == nil || m.dropped[u.node] {
+			continue
+		}
+		switch n := u.node.(type) {
+		case *ast.FuncDecl:
+			m.passValues(u, n)
+		case *ast.TypeSpec:
+			for _, param := range m.sortedNeeds(m.valueNeeds, u.obj) {
+				u.strct.Fields.List = append(u.strct.Fields.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(lowerFirst(param))}, Type: m.valueType(param)})
+			}
+		}
+	}
+
+	// Declare the type parameters and instantiate the uses of the
+	// generic types and functions
+	for _, u := range m.units {
+		if u.obj == nil || m.dropped[u.node] {
+			continue
+		}
+		switch n := u.node.(type) {
+		case *ast.FuncDecl:
+			if !u.method {
+				n.Type.TypeParams = typeParamList(u.obj)
+			}
+		case *ast.TypeSpec:
+			n.TypeParams = typeParamList(u.obj)
+		}
+	}
+	for _, f := range m.pkg.files {
+		astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+			id, ok := c.Node().(*ast.Ident)
+			if !ok {
+				return true
+			}
+			obj := info.Uses[id]
+			if obj == nil || obj.Parent() != m.pkg.types.Scope() {
+				return true
+			}
+			params := m.sortedNeeds(m.typeNeeds, obj)
+			if len(params) == 0 {
+				return true
+			}
+			var indices []ast.Expr
+			for _, param := range params {
+				indices = append(indices, ast.NewIdent(param))
+			}
+			if len(indices) == 1 {
+				c.Replace(&ast.IndexExpr{X: id, Index: indices[0]})
+			} else {
+				c.Replace(&ast.IndexListExpr{X: id, Indices: indices})
+			}
+			return true
+		})
+	}
+
+	for _, f := range m.pkg.files {
+		dropDecls(f, m.dropped)
+		dropTemplateComments(f)
+		if m.usesCmp {
+			astutil.AddImport(m.pkg.fset, f, "cmp")
+		}
+	}
+	return nil
+}
+
+// passValues rewrites the function or method d of u to receive the
+// value parameters it needs and pass them on
+func (m *migration) passValues(u *migrateUnit, d *ast.FuncDecl) {
+	info := m.pkg.info
+	needs := m.sortedNeeds(m.valueNeeds, u.obj)
+	if len(needs) == 0 {
+		return
+	}
+	var value func(param string) ast.Expr
+	if u.method {
+		recv := d.Recv.List[0]
+		if len(recv.Names) == 0 || recv.Names[0].Name == "_" {
+			recv.Names = []*ast.Ident{ast.NewIdent(m.freeName(d, lowerFirst(u.obj.Name())[:1], nil))}
+		}
+		name := recv.Names[0].Name
+		value = func(param string) ast.Expr {
+			return &ast.SelectorExpr{X: ast.NewIdent(name), Sel: ast.NewIdent(lowerFirst(param))}
+		}
+	} else {
+		names := map[string]string{}
+		var fields []*ast.Field
+		for _, param := range needs {
+			names[param] = m.freeName(d, lowerFirst(param), m.valueParams[param])
+			fields = append(fields, &ast.Field{Names: []*ast.Ident{ast.NewIdent(names[param])}, Type: m.valueType(param)})
+		}
+		d.Type.Params.List = append(fields, d.Type.Params.List...)
+		value = func(param string) ast.Expr {
+			return ast.NewIdent(names[param])
+		}
+	}
+	if d.Body == nil {
+		return
+	}
+	astutil.Apply(d.Body, nil, func(c *astutil.Cursor) bool {
+		switch x := c.Node().(type) {
+		case *ast.Ident:
+			if param := m.valueParam(info.Uses[x]); param != "" {
+				c.Replace(value(param))
+			}
+		case *ast.CallExpr:
+			id, ok := x.Fun.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			var args []ast.Expr
+			for _, param := range m.sortedNeeds(m.valueNeeds, info.Uses[id]) {
+				args = append(args, value(param))
+			}
+			x.Args = append(args, x.Args...)
+		case *ast.CompositeLit:
+			id, ok := x.Type.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			for _, param := range m.sortedNeeds(m.valueNeeds, info.Uses[id]) {
+				x.Elts = append(x.Elts, &ast.KeyValueExpr{Key: ast.NewIdent(lowerFirst(param)), Value: value(param)})
+			}
+		}
+		return true
+	})
+}
+
+// dropTemplateComments removes the "// template" comments from f
+func dropTemplateComments(f *ast.File) {
+	var comments []*ast.CommentGroup
+	for _, cg := range f.Comments {
+		var list []*ast.Comment
+		for _, x := range cg.List {
+			if !matchTemplateType.MatchString(x.Text) && !matchConstraint.MatchString(x.Text) {
+				list = append(list, x)
+			}
+		}
+		if len(list) > 0 {
+			cg.List = list
+			comments = append(comments, cg)
+		}
+	}
+	f.Comments = comments
+}
+
+// render formats the files of the generic package into dir
+func (m *migration) render(dir string) ([]File, error) {
+	var files []File
+	for _, f := range m.pkg.files {
+		path := filepath.Join(dir, filepath.Base(m.pkg.fset.Position(f.Pos()).Filename))
+		b := new(bytes.Buffer)
+		if err := format.Node(b, m.pkg.fset, f); err != nil {
+			return nil, fmt.Errorf("failed to format output: %w", err)
+		}
+		src, err := imports.Process(path, b.Bytes(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fix imports: %w", err)
+		}
+		files = append(files, File{Path: path, Content: src})
+	}
+	return files, nil
+}
+
+// check type checks the generic package, reporting the errors
+func (m *migration) check(files []File) {
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	for _, f := range files {
+		pf, err := parser.ParseFile(fset, f.Path, f.Content, 0)
+		if err != nil {
+			m.unconverted = append(m.unconverted, Unconverted{Reason: fmt.Sprintf("the generic package doesn't parse: %v", err)})
+			return
+		}
+		parsed = append(parsed, pf)
+	}
+	source := importer.ForCompiler(fset, "source", nil)
+	conf := &types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if imp, ok := m.lp.pkg.Imports[path]; ok && imp.Types != nil {
+				return imp.Types, nil
+			}
+			return source.Import(path)
+		}),
+		Error: func(err error) {
+			if e, ok := err.(types.Error); ok {
+				m.unconverted = append(m.unconverted, Unconverted{Pos: e.Fset.Position(e.Pos), Reason: "the generic package doesn't compile: " + e.Msg})
+			}
+		},
+	}
+	_, _ = conf.Check(m.pkg.types.Path(), fset, parsed, nil)
+}
+
+// checkGoVersion reports if the go.mod of dir is too old for the
+// generic package
+func (m *migration) checkGoVersion(dir string) {
+	goVersion, err := moduleGoVersion(dir)
+	if err != nil || goVersion == "" || !version.IsValid(goVersion) {
+		return
+	}
+	need := genericsVersion
+	if m.usesCmp {
+		need = orderedVersion
+	}
+	if version.Compare(goVersion, need) < 0 {
+		m.unconverted = append(m.unconverted, Unconverted{Pos: token.Position{Filename: filepath.Join(moduleRoot(dir), "go.mod")}, Reason: fmt.Sprintf("the generic package needs %s but the go.mod has %s", need, goVersion)})
+	}
+}
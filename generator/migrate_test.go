@@ -0,0 +1,187 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const migrateTemplate = `package tt
+
+import "fmt"
+
+// template type Sorted(A, Less)
+// template constraint A comparable
+type A int
+
+func Less(a, b A) bool { return a < b }
+
+// A Sorted holds As in order
+type Sorted struct {
+	items []A
+}
+
+func NewSorted() *Sorted { return &Sorted{} }
+
+func (s *Sorted) Add(a A) {
+	i := search(s.items, a)
+	s.items = append(s.items[:i], append([]A{a}, s.items[i:]...)...)
+}
+
+func (s *Sorted) String() string { return fmt.Sprint(s.items) }
+
+func search(items []A, a A) int {
+	for i, x := range items {
+		if !Less(x, a) {
+			return i
+		}
+	}
+	return len(items)
+}
+`
+
+func TestMigrate(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": migrateTemplate})
+	defer cleanup()
+	result, err := New().GenerateMigration(context.Background(), MigrateOptions{Dir: output, Package: "input", OutDir: "generic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Unconverted) != 0 {
+		t.Errorf("unexpected unconverted %v", result.Unconverted)
+	}
+	if len(result.Files) != 1 {
+		t.Fatalf("expecting 1 file, got %d", len(result.Files))
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{
+		"type Sorted[A comparable] struct {\n\titems []A\n\tless  func(a A, b A) bool\n}",
+		"func NewSorted[A comparable](less func(a A, b A) bool) *Sorted[A] { return &Sorted[A]{less: less} }",
+		"func (s *Sorted[A]) Add(a A) {\n\ti := search[A](s.less, s.items, a)",
+		"func search[A comparable](less func(a A, b A) bool, items []A, a A) int {",
+		"if !less(x, a) {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expecting %q in\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"template", "type A int", "func Less"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("unexpected %q in\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestMigrateTemplates(t *testing.T) {
+	for _, pkg := range []string{"../set", "../sort", "../list", "../ring", "../treemap"} {
+		result, err := New().GenerateMigration(context.Background(), MigrateOptions{Package: pkg, OutDir: t.TempDir()})
+		if err != nil {
+			t.Errorf("%s: %v", pkg, err)
+		} else if len(result.Unconverted) != 0 {
+			t.Errorf("%s: unexpected unconverted %v", pkg, result.Unconverted)
+		}
+	}
+}
+
+func TestMigrateUnconverted(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "array size",
+			src:  "// template type Ring(A, N)\ntype A int\n\nconst N = 4\n\ntype Ring struct{ buf [N]A }\n",
+			want: "N can't be converted: it is used as an array length",
+		},
+		{
+			name: "not a struct",
+			src:  "// template type Stack(A, Size)\ntype A int\n\nfunc Size() int { return 4 }\n\ntype Stack []A\n\nfunc (s Stack) Full() bool { return len(s) >= Size() }\n",
+			want: "Stack isn't a struct to hold it",
+		},
+		{
+			name: "bad implements",
+			src:  "// template type Set(A)\n// template constraint A implements fmt.Stringer[\ntype A int\n\ntype Set map[A]bool\n",
+			want: "constraint implements fmt.Stringer[ on type parameter A can't be converted",
+		},
+		{
+			name: "format func",
+			src:  "// template type Set(A)\ntype A int\n\ntype Set map[A]bool\n\n//template format\nvar __formatTo func(interface{}) A\n",
+			want: "format funcs can't be converted",
+		},
+		{
+			name: "missing constraint",
+			src:  "// template type Max(A)\ntype A int\n\nfunc Max(a, b A) A {\n\tif a > b {\n\t\treturn a\n\t}\n\treturn b\n}\n",
+			want: "the generic package doesn't compile",
+		},
+	} {
+		output, cleanup := makeModules(t, map[string]string{"main.go": "package tt\n\n" + test.src})
+		result, err := New().GenerateMigration(context.Background(), MigrateOptions{Dir: output, Package: "input", OutDir: "generic"})
+		cleanup()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		found := false
+		for _, u := range result.Unconverted {
+			found = found || strings.Contains(u.Reason, test.want)
+		}
+		if !found {
+			t.Errorf("%s: expecting %q in %v", test.name, test.want, result.Unconverted)
+		}
+	}
+}
+
+func TestMigrateHeap(t *testing.T) {
+	result, err := New().GenerateMigration(context.Background(), MigrateOptions{Package: "../heap", OutDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Less can no longer be passed so the change is reported
+	if len(result.Unconverted) != 1 || !strings.Contains(result.Unconverted[0].Reason, "Less becomes a method of the constraint of A") {
+		t.Errorf("expecting Less reported, got %v", result.Unconverted)
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{
+		"type Heap[A interface{ Less(b A) bool }] []A",
+		"func (h *Heap[A]) Push(x A) {",
+		"if i == j || !hs[j].Less(hs[i]) {",
+		"if j2 := j1 + 1; j2 < n && !hs[j1].Less(hs[j2]) {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expecting %q in\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"func Less", "Less[A]", "a < b"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("unexpected %q in\n%s", unwanted, got)
+		}
+	}
+}
+
+func TestMigrateForce(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": "package tt\n\n// template type Ring(A, N)\ntype A int\n\nconst N = 4\n\ntype Ring struct{ buf [N]A }\n"})
+	defer cleanup()
+	generic := path.Join(output, "generic", "main.go")
+
+	result, err := New().Migrate(context.Background(), MigrateOptions{Dir: output, Package: "input", OutDir: "generic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Unconverted) == 0 {
+		t.Fatal("expecting unconverted constructs")
+	}
+	if _, err := os.Stat(generic); !os.IsNotExist(err) {
+		t.Errorf("expecting nothing written, got %v", err)
+	}
+
+	_, err = New().Migrate(context.Background(), MigrateOptions{Dir: output, Package: "input", OutDir: "generic", Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(generic); err != nil {
+		t.Errorf("expecting the generic package written with Force: %v", err)
+	}
+}
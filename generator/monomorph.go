@@ -0,0 +1,359 @@
+// Instantiates generic packages by specialising their declarations
+
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// isGenericTemplate returns true if the package has no "// template
+// type" comments but declares generic types or functions, which are
+// then used as the templates
+func isGenericTemplate(pkg *typedPackage) bool {
+	for _, f := range pkg.files {
+		for _, cg := range f.Comments {
+			for _, c := range cg.List {
+				if matchTemplateType.MatchString(c.Text) {
+					return false
+				}
+			}
+		}
+	}
+	return len(genericDecls(pkg)) > 0
+}
+
+// genericDecls returns the package level generic types and functions
+// of pkg in the order they are declared
+func genericDecls(pkg *typedPackage) (objs []types.Object) {
+	for _, f := range pkg.files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Type.TypeParams != nil {
+					objs = append(objs, pkg.info.Defs[d.Name])
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if s, ok := spec.(*ast.TypeSpec); ok && s.TypeParams != nil {
+						objs = append(objs, pkg.info.Defs[s.Name])
+					}
+				}
+			}
+		}
+	}
+	return objs
+}
+
+// typeParams returns the type parameters of the generic type or
+// function obj, or nil if it isn't generic
+func typeParams(obj types.Object) *types.TypeParamList {
+	switch typ := obj.Type().(type) {
+	case *types.Named:
+		return typ.TypeParams()
+	case *types.Signature:
+		return typ.TypeParams()
+	}
+	return nil
+}
+
+// findGenericDefinition chooses the generic type or function of the
+// package to instantiate, whose type parameters are the template
+// parameters.  If there is more than one exported generic type, or no
+// exported generic type and more than one exported generic function,
+// then the instance must choose one.
+func (t *template) findGenericDefinition(pkg *typedPackage) error {
+	t.templateNames = nil
+	t.allParams = map[string]bool{}
+	var exportedTypes, exported []string
+	for _, obj := range genericDecls(pkg) {
+		t.templateNames = append(t.templateNames, obj.Name())
+		if !obj.Exported() {
+			continue
+		}
+		exported = append(exported, obj.Name())
+		if _, ok := obj.(*types.TypeName); ok {
+			exportedTypes = append(exportedTypes, obj.Name())
+		}
+	}
+	t.templateName = t.definition
+	if t.templateName == "" {
+		choices := exportedTypes
+		if len(choices) == 0 {
+			choices = exported
+		}
+		if len(choices) == 0 {
+			choices = t.templateNames
+		}
+		if len(choices) > 1 {
+			return fmt.Errorf("%s declares generics %s - choose one with %s = Generic(...)", t.Package, strings.Join(choices, ", "), t.Name)
+		}
+		t.templateName = choices[0]
+	}
+	obj := pkg.types.Scope().Lookup(t.templateName)
+	if obj == nil || typeParams(obj).Len() == 0 {
+		return &MissingDefinitionError{Package: t.Package, Name: t.templateName}
+	}
+	params := typeParams(obj)
+	t.templateArgs = nil
+	for i := 0; i < params.Len(); i++ {
+		param := params.At(i).Obj().Name()
+		t.templateArgs = append(t.templateArgs, param)
+		t.allParams[param] = true
+	}
+	if err := t.resolveArgs(pkg.files); err != nil {
+		return err
+	}
+	for i, to := range t.Args {
+		t.templateArgsMap[t.templateArgs[i]] = to
+	}
+	t.debugf("templateName = %v, templateArgs = %v of generic %s", t.templateName, t.templateArgs, t.Package)
+	return nil
+}
+
+// checkTypeParams checks the arguments satisfy the constraints on the
+// type parameters of the chosen generic.  Arguments which can't be
+// type checked in the destination package aren't checked, as the
+// compiler will check those.
+func (t *template) checkTypeParams(pkg *typedPackage) error {
+	obj := pkg.types.Scope().Lookup(t.templateName)
+	dest, err := t.destinationPackage(nil)
+	if err != nil {
+		return err
+	}
+	var targs []types.Type
+	for i, param := range t.templateArgs {
+		arg := t.qualify(t.Args[i])
+		tv, err := types.Eval(token.NewFileSet(), dest, token.NoPos, arg)
+		if err != nil {
+			t.debugf("Not checking the constraints of %s: %v", t.templateName, err)
+			return nil
+		}
+		if !tv.IsType() {
+			return &ConstraintError{Template: t.templateName, Param: param, Arg: arg,
+				Constraint: typeParams(obj).At(i).Constraint().String(), Reason: "not a type"}
+		}
+		targs = append(targs, tv.Type)
+	}
+	if _, err := types.Instantiate(nil, obj.Type(), targs, true); err != nil {
+		var argErr *types.ArgumentError
+		if !errors.As(err, &argErr) {
+			return fmt.Errorf("can't instantiate %s: %w", t.templateName, err)
+		}
+		i := argErr.Index
+		return &ConstraintError{Template: t.templateName, Param: t.templateArgs[i], Arg: t.qualify(t.Args[i]),
+			Constraint: typeParams(obj).At(i).Constraint().String(), Reason: argErr.Err.Error()}
+	}
+	return nil
+}
+
+// monomorphize turns the generic declarations of files into ordinary
+// ones.  The type parameter lists and the type arguments of the
+// instantiations are removed and the declarations which can't be
+// reached from the chosen generic, its constructors or the
+// declarations named like it whose types use it are dropped.  Other
+// generics named like it, eg IsSorted for Sort, are independent of it
+// so are only kept if it uses them.  The type parameters
+// of those left are bound to the parameters of the chosen generic
+// through the instantiations, and mapped to its arguments.
+//
+// Each generic can only be specialised once, so those instantiated
+// with anything other than type parameters, or with different
+// parameters of the chosen generic, can't be monomorphized.
+func (t *template) monomorphize(pkg *typedPackage) error {
+	info, files := pkg.info, pkg.files
+	scope := pkg.types.Scope()
+	isGeneric := func(obj types.Object) bool {
+		return obj != nil && obj.Parent() == scope && typeParams(obj).Len() > 0
+	}
+
+	// The roots are the chosen generic, its constructors and those
+	// named like it, as in the templates, whose types use it so their
+	// type parameters are bound to its
+	chosen := scope.Lookup(t.templateName)
+	typeNames := []string{t.templateName}
+	for _, obj := range genericDecls(pkg) {
+		if _, ok := obj.(*types.TypeName); ok {
+			typeNames = append(typeNames, obj.Name())
+		}
+	}
+	usesChosen := func(n ast.Node) (found bool) {
+		ast.Inspect(n, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && info.Uses[id] == chosen {
+				found = true
+			}
+			return !found
+		})
+		return found
+	}
+	namedLike := func(name string) bool {
+		return strings.Contains(name, t.templateName) && owner(name, typeNames) == t.templateName
+	}
+	roots := map[string]bool{t.templateName: true}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && usesChosen(d.Type) && (namedLike(d.Name.Name) || d.Type.Results != nil && usesChosen(d.Type.Results)) {
+					roots[d.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if s, ok := spec.(*ast.TypeSpec); ok && namedLike(s.Name.Name) && usesChosen(s.Type) {
+						roots[s.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+
+	// Strip the type parameters, keeping the instantiations to bind
+	instances := map[*ast.Ident]types.Instance{}
+	for _, f := range files {
+		astutil.Apply(f, func(c *astutil.Cursor) bool {
+			switch n := c.Node().(type) {
+			case *ast.TypeSpec:
+				n.TypeParams = nil
+			case *ast.FuncType:
+				n.TypeParams = nil
+			case *ast.Ident:
+				if inst, found := info.Instances[n]; found && isGeneric(info.Uses[n]) {
+					instances[n] = inst
+				}
+			case *ast.IndexExpr:
+				if id, ok := n.X.(*ast.Ident); ok && isGeneric(info.Uses[id]) {
+					c.Replace(id)
+					if inst, found := info.Instances[id]; found {
+						instances[id] = inst
+					}
+					return false
+				}
+			case *ast.IndexListExpr:
+				if id, ok := n.X.(*ast.Ident); ok && isGeneric(info.Uses[id]) {
+					c.Replace(id)
+					if inst, found := info.Instances[id]; found {
+						instances[id] = inst
+					}
+					return false
+				}
+			}
+			return true
+		}, nil)
+	}
+
+	t.keepReachable(scope, info, files, true, func(name string) bool {
+		name, method, _ := strings.Cut(name, ".")
+		if method != "" {
+			return false
+		}
+		return roots[name]
+	})
+
+	// Find the type parameters of the generics left and those used
+	type edge struct {
+		a, b *types.TypeName
+		at   token.Pos
+	}
+	var edges []edge
+	var err error
+	var unbound []*types.TypeName
+	declaredIn := map[*types.TypeName]string{}
+	declare := func(params *types.TypeParamList, in string) {
+		for i := 0; i < params.Len(); i++ {
+			obj := params.At(i).Obj()
+			declaredIn[obj] = in
+			unbound = append(unbound, obj)
+		}
+	}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			ast.Inspect(decl, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.FuncDecl:
+					fn, ok := info.Defs[n.Name].(*types.Func)
+					if !ok {
+						break
+					}
+					sig := fn.Type().(*types.Signature)
+					declare(sig.TypeParams(), fn.Name())
+					if recv := recvTypeName(info, n); recv != nil && sig.RecvTypeParams().Len() > 0 {
+						declare(sig.RecvTypeParams(), recv.Name()+"."+fn.Name())
+						params := typeParams(recv)
+						for i := 0; i < params.Len(); i++ {
+							edges = append(edges, edge{sig.RecvTypeParams().At(i).Obj(), params.At(i).Obj(), n.Pos()})
+						}
+					}
+				case *ast.TypeSpec:
+					if obj := info.Defs[n.Name]; isGeneric(obj) {
+						declare(typeParams(obj), obj.Name())
+					}
+				case *ast.Ident:
+					inst, found := instances[n]
+					if !found {
+						break
+					}
+					params := typeParams(info.Uses[n])
+					for i := 0; i < inst.TypeArgs.Len(); i++ {
+						arg, ok := inst.TypeArgs.At(i).(*types.TypeParam)
+						if !ok {
+							if err == nil {
+								err = t.monomorphizeError(pkg, n.Pos(), "%s is instantiated with %s which isn't a type parameter", n.Name, inst.TypeArgs.At(i))
+							}
+							return false
+						}
+						edges = append(edges, edge{params.At(i).Obj(), arg.Obj(), n.Pos()})
+					}
+				}
+				return true
+			})
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Bind the type parameters through the instantiations starting
+	// from those of the chosen generic
+	bound := map[*types.TypeName]string{}
+	params := typeParams(chosen)
+	for i := 0; i < params.Len(); i++ {
+		bound[params.At(i).Obj()] = t.templateArgs[i]
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, e := range edges {
+			a, aBound := bound[e.a]
+			b, bBound := bound[e.b]
+			switch {
+			case aBound && bBound && a != b:
+				return t.monomorphizeError(pkg, e.at, "%s of %s is instantiated with both %s and %s of %s", e.a.Name(), declaredIn[e.a], a, b, t.templateName)
+			case aBound && !bBound:
+				bound[e.b] = a
+				changed = true
+			case bBound && !aBound:
+				bound[e.a] = b
+				changed = true
+			}
+		}
+	}
+	for _, obj := range unbound {
+		param, found := bound[obj]
+		if !found {
+			return t.monomorphizeError(pkg, obj.Pos(), "%s of %s isn't bound to a type parameter of %s", obj.Name(), declaredIn[obj], t.templateName)
+		}
+		t.mappings[obj] = t.templateArgsMap[param]
+	}
+	return nil
+}
+
+// monomorphizeError returns an error about the generic declaration at
+// pos
+func (t *template) monomorphizeError(pkg *typedPackage, pos token.Pos, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: can't monomorphize %s: %s", pkg.fset.Position(pos), t.templateName, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,239 @@
+package generator
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const monomorphTemplate = `package tt
+
+import "cmp"
+
+// A Tree is a sorted binary tree of Ks
+type Tree[K cmp.Ordered, V any] struct {
+	root *node[K, V]
+}
+
+type node[Key cmp.Ordered, Value any] struct {
+	key         Key
+	value       Value
+	left, right *node[Key, Value]
+}
+
+// NewTree makes an empty Tree
+func NewTree[K cmp.Ordered, V any]() *Tree[K, V] { return &Tree[K, V]{} }
+
+func (t *Tree[K, V]) Put(k K, v V) {
+	p := &t.root
+	for *p != nil && (*p).key != k {
+		if k < (*p).key {
+			p = &(*p).left
+		} else {
+			p = &(*p).right
+		}
+	}
+	*p = &node[K, V]{key: k, value: v}
+}
+
+func (t *Tree[X, Y]) Get(k X) (v Y, ok bool) {
+	if n := find(t.root, k); n != nil {
+		return n.value, true
+	}
+	return v, false
+}
+
+func find[K cmp.Ordered, V any](n *node[K, V], k K) *node[K, V] {
+	for n != nil && n.key != k {
+		if k < n.key {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return n
+}
+
+// Max returns the larger of a and b
+func Max[T cmp.Ordered](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+`
+
+func TestMonomorphize(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": monomorphTemplate})
+	defer cleanup()
+	writeFile(t, path.Join(output, "main.go"), "package main\n\nfunc main() {\n\tt := NewIntTree()\n\tt.Put(1, \"one\")\n\tprintln(t.Get(MaxInt(1, 0)))\n}\n")
+
+	for _, test := range []struct {
+		instance string
+		want     []string
+		unwanted []string
+	}{
+		{
+			instance: "IntTree(int, string)",
+			want: []string{
+				"// A IntTree is a sorted binary tree of Ks\ntype IntTree struct {\n\troot *nodeIntTree\n}",
+				"type nodeIntTree struct {\n\tkey         int\n\tvalue       string\n\tleft, right *nodeIntTree\n}",
+				"func NewIntTree() *IntTree { return &IntTree{} }",
+				"func (t *IntTree) Put(k int, v string) {",
+				"*p = &nodeIntTree{key: k, value: v}",
+				"func (t *IntTree) Get(k int) (v string, ok bool) {\n\tif n := findIntTree(t.root, k); n != nil {",
+				"func findIntTree(n *nodeIntTree, k int) *nodeIntTree {",
+			},
+			unwanted: []string{"[", "cmp", "Max"},
+		},
+		{
+			instance: "MaxInt = Max(int)",
+			want:     []string{"// MaxInt returns the larger of a and b\nfunc MaxInt(a, b int) int {"},
+			unwanted: []string{"Tree", "cmp"},
+		},
+	} {
+		result, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: test.instance})
+		if err != nil {
+			t.Errorf("%s: %v", test.instance, err)
+			continue
+		}
+		got := string(result.Files[0].Content)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: expecting %q in\n%s", test.instance, want, got)
+			}
+		}
+		body := got[strings.Index(got, "\npackage "):]
+		for _, unwanted := range test.unwanted {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%s: unexpected %q in\n%s", test.instance, unwanted, got)
+			}
+		}
+		writeFile(t, path.Join(output, path.Base(result.Files[0].Path)), got)
+	}
+
+	// The specialised copies compile
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.LoadSyntax, Dir: output}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range pkgs[0].Errors {
+		t.Errorf("destination doesn't compile: %v", e)
+	}
+}
+
+func TestMonomorphizeMigrated(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{})
+	defer cleanup()
+	input := path.Join(path.Dir(output), "input")
+	for _, pkg := range []string{"sort", "treemap"} {
+		result, err := New().Migrate(context.Background(), MigrateOptions{Package: "../" + pkg, OutDir: path.Join(input, pkg)})
+		if err != nil {
+			t.Fatalf("%s: %v", pkg, err)
+		}
+		if len(result.Unconverted) != 0 {
+			t.Fatalf("%s: unexpected unconverted %v", pkg, result.Unconverted)
+		}
+	}
+
+	for _, test := range []struct {
+		pkg      string
+		instance string
+		want     []string
+		unwanted []string
+	}{
+		{
+			pkg:      "input/sort",
+			instance: "SortStr = Sort(string)",
+			want:     []string{"func SortStr(less func(a string, b string) bool, data []string) {"},
+			unwanted: []string{"IsSorted", "[A"},
+		},
+		{
+			pkg:      "input/treemap",
+			instance: "IntMap = TreeMap(int, string)",
+			want: []string{
+				"func NewIntMap(less func(a int, b int) bool) *IntMap {",
+				"func (t *IntMap) Set(key int, value string) {",
+				"func (t *IntMap) Iterator() ForwardIteratorIntMap {",
+			},
+			unwanted: []string{"[Key"},
+		},
+	} {
+		result, err := New().Generate(context.Background(), Options{Dir: output, Package: test.pkg, Instance: test.instance})
+		if err != nil {
+			t.Errorf("%s: %v", test.instance, err)
+			continue
+		}
+		got := string(result.Files[0].Content)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: expecting %q in\n%s", test.instance, want, got)
+			}
+		}
+		body := got[strings.Index(got, "\npackage "):]
+		for _, unwanted := range test.unwanted {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("%s: unexpected %q in\n%s", test.instance, unwanted, got)
+			}
+		}
+		writeFile(t, path.Join(output, path.Base(result.Files[0].Path)), got)
+	}
+
+	// The specialised copies compile
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.LoadSyntax, Dir: output}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range pkgs[0].Errors {
+		t.Errorf("destination doesn't compile: %v", e)
+	}
+}
+
+func TestMonomorphizeErrors(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": monomorphTemplate})
+	defer cleanup()
+
+	var constraintErr *ConstraintError
+	_, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "FuncTree(func(), int)"})
+	if !errors.As(err, &constraintErr) || constraintErr.Param != "K" {
+		t.Errorf("expecting constraint error on K, got %v", err)
+	}
+	var arityErr *ArityError
+	_, err = New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "IntTree(int)"})
+	if !errors.As(err, &arityErr) {
+		t.Errorf("expecting arity error, got %v", err)
+	}
+
+	for _, test := range []struct {
+		src      string
+		instance string
+		want     string
+	}{
+		{
+			src:      "type Set[K comparable] map[K]bool\n\ntype Bag[K comparable] map[K]int\n",
+			instance: "IntSet(int)",
+			want:     "declares generics Set, Bag - choose one with IntSet = Generic(...)",
+		},
+		{
+			src:      "type Set[K comparable] map[K]bool\n\nfunc (s Set[K]) Lens() Set[int] { return nil }\n",
+			instance: "StringSet(string)",
+			want:     "Set is instantiated with int which isn't a type parameter",
+		},
+		{
+			src:      "type Pair[K, V any] struct {\n\tk K\n\tv V\n}\n\nfunc (p Pair[K, V]) Swap() Pair[V, K] { return Pair[V, K]{p.v, p.k} }\n",
+			instance: "IntPair(int, string)",
+			want:     "K of Pair is instantiated with both K and V of Pair",
+		},
+	} {
+		output, cleanup := makeModules(t, map[string]string{"main.go": "package tt\n\n" + test.src})
+		_, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: test.instance})
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expecting %q, got %v", test.instance, test.want, err)
+		}
+	}
+}
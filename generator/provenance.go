@@ -0,0 +1,175 @@
+// Records where a generated file came from
+
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Version is the version of gotemplate recorded in generated files
+const Version = "v0.07"
+
+// provenancePrefix starts the line of the header of a generated file
+// which holds its Provenance as JSON
+const provenancePrefix = "// gotemplate: "
+
+// Provenance records how a generated file was made.  It is written as
+// a line of JSON in the header of each generated file.
+type Provenance struct {
+	Template   string   `json:"template"`             // import path of the template package
+	Version    string   `json:"version,omitempty"`    // module version of the template package if known
+	Hash       string   `json:"hash"`                 // hash of the template source
+	Instance   string   `json:"instance"`             // instance name, eg "MySet"
+	Definition string   `json:"definition,omitempty"` // template chosen if the package defines several
+	Alias      string   `json:"alias,omitempty"`      // instance this is an alias of if any
+	Args       []string `json:"args"`                 // template arguments, eg ["string"]
+	Flags      []string `json:"flags,omitempty"`      // gotemplate flags which affect the output
+	Generator  string   `json:"generator"`            // version of gotemplate
+}
+
+// header makes the header for a generated file
+func (p *Provenance) header() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode provenance: %w", err)
+	}
+	return genHeader + provenancePrefix + string(data) + "\n\n", nil
+}
+
+// ReadProvenance reads the Provenance from the header of a file
+// generated by gotemplate.  It returns nil if there isn't one.
+func ReadProvenance(src []byte) (*Provenance, error) {
+	if !IsGenerated(src) {
+		return nil, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if !strings.HasPrefix(line, provenancePrefix) {
+			continue
+		}
+		p := new(Provenance)
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, provenancePrefix)), p); err != nil {
+			return nil, fmt.Errorf("bad gotemplate header: %w", err)
+		}
+		return p, nil
+	}
+	return nil, scanner.Err()
+}
+
+// flags returns the command line flags which make opts
+func (opts *Options) flags() (flags []string) {
+	if opts.OutFmt != DefaultOutFmt {
+		flags = append(flags, "-outfmt", opts.OutFmt)
+	}
+	if opts.PackageName != "" {
+		flags = append(flags, "-pkg", opts.PackageName)
+	}
+	if opts.RawName {
+		flags = append(flags, "-r")
+	}
+	if opts.Test {
+		flags = append(flags, "-t")
+	}
+	if opts.Split {
+		flags = append(flags, "-split")
+	}
+	if len(opts.Keep) > 0 {
+		flags = append(flags, "-keep", strings.Join(opts.Keep, ","))
+	}
+	if opts.Prune {
+		flags = append(flags, "-prune")
+	}
+	if opts.Disambiguate {
+		flags = append(flags, "-disambiguate")
+	}
+	if opts.Alias {
+		flags = append(flags, "-alias")
+	}
+	if len(opts.Imports) > 0 {
+		flags = append(flags, "-import", strings.Join(opts.Imports, ","))
+	}
+	if opts.Generic != "" {
+		flags = append(flags, "-generic", opts.Generic)
+	}
+	return flags
+}
+
+// hashSources makes a hash of the template package from the names and
+// contents of its files
+func hashSources(names []string, srcs [][]byte) string {
+	h := sha256.New()
+	for i, name := range names {
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.Base(name), len(srcs[i]))
+		h.Write(srcs[i])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Options returns the Options to regenerate the file in dir which p
+// was read from
+func (p *Provenance) Options(dir string) (Options, error) {
+	opts, args, err := parseFlags(p.Flags)
+	if err != nil {
+		return opts, fmt.Errorf("bad flags in gotemplate header: %w", err)
+	}
+	if len(args) != 0 || p.Template == "" || p.Instance == "" {
+		return opts, errors.New("incomplete gotemplate header")
+	}
+	opts.Dir = dir
+	opts.Package = p.Template
+	opts.Instance = p.Instance + " = " + p.Definition + "(" + strings.Join(p.Args, ", ") + ")"
+	if p.Definition == "" {
+		opts.Instance = p.Instance + "(" + strings.Join(p.Args, ", ") + ")"
+	}
+	return opts, nil
+}
+
+// ReadGenerated reads the provenance headers of the files generated by
+// gotemplate in dir and returns the Options to regenerate each of
+// them.  Instances which generate more than one file are only
+// returned once.  The paths of generated files without a provenance
+// header are returned in missing.
+func ReadGenerated(dir string) (instances []Options, missing []string, err error) {
+	paths, err := FindGenerated(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := map[string]bool{}
+	for _, name := range paths {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %q: %w", name, err)
+		}
+		p, err := ReadProvenance(src)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if p == nil {
+			missing = append(missing, name)
+			continue
+		}
+		opts, err := p.Options(dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		key := strings.Join(append([]string{p.Template, opts.Instance}, p.Flags...), "\x00")
+		if !seen[key] {
+			seen[key] = true
+			instances = append(instances, opts)
+		}
+	}
+	return instances, missing, nil
+}
@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestReadGenerated(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{
+		"cache.go": "package cache\n\n// template type Cache(A)\ntype A int\n\ntype Cache map[A]entry\n",
+		"entry.go": "package cache\n\ntype entry struct{ v A }\n",
+	})
+	defer cleanup()
+
+	opts := Options{Dir: output, Package: "input", Instance: "IntCache(int)", Split: true, RawName: true}
+	result, err := Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	if len(result.Files) != 2 {
+		t.Fatalf("Expecting 2 files, got %d", len(result.Files))
+	}
+	old := path.Join(output, "gotemplate_old.go")
+	if err := ioutil.WriteFile(old, []byte(genHeader+"\npackage main\n"), 0600); err != nil {
+		t.Fatalf("Failed to write %q: %v", old, err)
+	}
+
+	instances, missing, err := ReadGenerated(output)
+	if err != nil {
+		t.Fatalf("ReadGenerated failed: %v", err)
+	}
+	if !reflect.DeepEqual(missing, []string{old}) {
+		t.Errorf("missing = %q", missing)
+	}
+	opts.OutFmt = DefaultOutFmt
+	if !reflect.DeepEqual(instances, []Options{opts}) {
+		t.Errorf("instances = %+v, want %+v", instances, []Options{opts})
+	}
+
+	// Regenerating from the header makes the same files
+	result, err = New().Generate(context.Background(), instances[0])
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Changed() {
+		t.Errorf("Regenerated files differ")
+	}
+}
+
+func TestExpandDirs(t *testing.T) {
+	output, cleanup := makeModules(t, nil)
+	defer cleanup()
+	src := path.Dir(output)
+
+	dirs, err := ExpandDirs([]string{src + "/...", output})
+	if err != nil {
+		t.Fatalf("ExpandDirs failed: %v", err)
+	}
+	want := []string{src, path.Join(src, "input"), output}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Errorf("ExpandDirs = %q, want %q", dirs, want)
+	}
+
+	if _, err := ExpandDirs([]string{path.Join(src, "potato")}); err == nil {
+		t.Errorf("Expecting error for missing directory")
+	}
+}
@@ -0,0 +1,365 @@
+// Finds the declarations reachable from the chosen template and the
+// declarations the destination package uses
+
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// declUnit is the smallest piece of a template file which can be
+// dropped - a function, a method or a single spec of a const, var or
+// type declaration
+type declUnit struct {
+	node    ast.Node       // the FuncDecl or Spec
+	defs    []types.Object // the objects it declares
+	recv    types.Object   // the receiver type name if it is a method
+	uses    []types.Object // the package level objects it refers to
+	methods []string       // the names of the methods it may call
+	root    bool           // if it must be kept
+	keep    bool
+}
+
+// dynamicMethods are the methods which are found at run time by the
+// fmt and encoding packages so are kept if their type is
+var dynamicMethods = map[string]bool{
+	"String":        true,
+	"GoString":      true,
+	"Error":         true,
+	"Format":        true,
+	"MarshalJSON":   true,
+	"UnmarshalJSON": true,
+	"MarshalText":   true,
+	"UnmarshalText": true,
+}
+
+// owner returns the template in names with the longest name which is
+// contained in name, or "" if there isn't one
+func owner(name string, names []string) (o string) {
+	for _, n := range names {
+		if strings.Contains(name, n) && len(n) > len(o) {
+			o = n
+		}
+	}
+	return o
+}
+
+// pruneDecls removes the declarations from files which can't be
+// reached from the chosen template.
+//
+// The roots are the declarations whose names contain the name of the
+// chosen template but not that of another template with a longer
+// name, and the init functions.  Methods are kept if their receiver
+// type is kept.
+func (t *template) pruneDecls(scope *types.Scope, info *types.Info, files []*ast.File) {
+	t.keepReachable(scope, info, files, true, func(name string) bool {
+		return owner(name, t.templateNames) == t.templateName
+	})
+}
+
+// pruneUnused removes the declarations which aren't used from files
+// after they have been renamed.
+//
+// The roots are the declarations named in the Keep option, as
+// "Name" or "Type.Method", and if the Prune option is set those whose
+// names are used in the destination package.  Methods are kept if
+// their receiver type is kept and they might be called, or with Prune
+// whatever they are, as the destination package may convert the type
+// to an interface it doesn't name the methods of.
+func (t *template) pruneUnused(scope *types.Scope, info *types.Info, files []*ast.File) error {
+	roots := map[string]bool{}
+	for _, name := range t.opts.Keep {
+		roots[name] = true
+	}
+	var used map[string]bool
+	if t.opts.Prune {
+		var err error
+		used, err = t.destinationNames()
+		if err != nil {
+			return err
+		}
+	}
+	t.keepReachable(scope, info, files, t.opts.Prune, func(name string) bool {
+		_, method, _ := strings.Cut(name, ".")
+		if method == "" {
+			method = name
+		}
+		return roots[name] || used[method]
+	})
+	return nil
+}
+
+// destinationNames returns all the identifiers used in the go files
+// of the destination package, except those this instance generates
+func (t *template) destinationNames() (map[string]bool, error) {
+	names, _, err := t.destinationFiles()
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	fset := token.NewFileSet()
+	for _, name := range names {
+		f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse destination package: %w", err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+			return true
+		})
+	}
+	return used, nil
+}
+
+// keepReachable removes the declarations from files which can't be
+// reached from the roots.  isRoot is passed the names of functions,
+// types, consts and vars and "Type.Method" for methods.
+//
+// If allMethods is set then all the methods of a type are kept with
+// it, otherwise only those which might be called.
+func (t *template) keepReachable(scope *types.Scope, info *types.Info, files []*ast.File, allMethods bool, isRoot func(name string) bool) {
+	var units []*declUnit
+	inspect := func(u *declUnit) {
+		ast.Inspect(u.node, func(n ast.Node) bool {
+			x, ok := n.(ast.Expr)
+			if !ok {
+				return true
+			}
+			// Values used as interfaces may have any of the
+			// interface methods called
+			u.methods = append(u.methods, interfaceMethods(info.TypeOf(x))...)
+			switch x := x.(type) {
+			case *ast.Ident:
+				obj := info.Uses[x]
+				if obj != nil && obj.Parent() == scope {
+					u.uses = append(u.uses, obj)
+				} else if fn, ok := obj.(*types.Func); ok && fn.Type().(*types.Signature).Recv() != nil {
+					u.methods = append(u.methods, fn.Name())
+				}
+			case *ast.CallExpr:
+				if sig, ok := info.TypeOf(x.Fun).(*types.Signature); ok {
+					for i := 0; i < sig.Params().Len(); i++ {
+						u.methods = append(u.methods, interfaceMethods(sig.Params().At(i).Type())...)
+					}
+				}
+			}
+			return true
+		})
+	}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				u := &declUnit{node: d}
+				inspect(u)
+				if def := info.Defs[d.Name]; def != nil {
+					u.defs = []types.Object{def}
+				}
+				if d.Recv != nil {
+					u.recv = recvTypeName(info, d)
+					u.root = isRoot(recvName(d) + "." + d.Name.Name)
+				} else {
+					u.root = d.Name.Name == "init" || isRoot(d.Name.Name)
+				}
+				units = append(units, u)
+			case *ast.GenDecl:
+				if d.Tok == token.IMPORT {
+					continue
+				}
+				for _, spec := range d.Specs {
+					u := &declUnit{node: spec}
+					inspect(u)
+					var names []*ast.Ident
+					switch s := spec.(type) {
+					case *ast.ValueSpec:
+						names = s.Names
+					case *ast.TypeSpec:
+						names = []*ast.Ident{s.Name}
+					}
+					for _, name := range names {
+						if def := info.Defs[name]; def != nil {
+							u.defs = append(u.defs, def)
+						}
+						u.root = u.root || isRoot(name.Name)
+					}
+					units = append(units, u)
+				}
+			}
+		}
+	}
+
+	// Keep units until nothing more is reachable
+	reachable := map[types.Object]bool{}
+	called := map[string]bool{}
+	for name := range dynamicMethods {
+		called[name] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, u := range units {
+			if u.keep {
+				continue
+			}
+			if !u.root && !anyReachable(u.defs, reachable) {
+				if u.recv == nil || !reachable[u.recv] {
+					continue
+				}
+				if !allMethods && !called[u.node.(*ast.FuncDecl).Name.Name] {
+					continue
+				}
+			}
+			u.keep = true
+			changed = true
+			for _, obj := range u.defs {
+				reachable[obj] = true
+			}
+			for _, obj := range u.uses {
+				reachable[obj] = true
+			}
+			for _, name := range u.methods {
+				called[name] = true
+			}
+		}
+	}
+
+	dropped := map[ast.Node]bool{}
+	for _, u := range units {
+		if !u.keep {
+			t.debugf("Dropping unreachable %v", u.defs)
+			dropped[u.node] = true
+		}
+	}
+	for _, f := range files {
+		dropDecls(f, dropped)
+	}
+}
+
+// interfaceMethods returns the names of the methods of typ if it is
+// an interface
+func interfaceMethods(typ types.Type) (names []string) {
+	if typ == nil {
+		return nil
+	}
+	iface, ok := typ.Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+	for i := 0; i < iface.NumMethods(); i++ {
+		names = append(names, iface.Method(i).Name())
+	}
+	return names
+}
+
+// anyReachable returns true if any of objs is reachable
+func anyReachable(objs []types.Object, reachable map[types.Object]bool) bool {
+	for _, obj := range objs {
+		if reachable[obj] {
+			return true
+		}
+	}
+	return false
+}
+
+// recvTypeName returns the type name of the receiver of the method d
+func recvTypeName(info *types.Info, d *ast.FuncDecl) types.Object {
+	fn, ok := info.Defs[d.Name].(*types.Func)
+	if !ok {
+		return nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	typ := recv.Type()
+	if p, ok := typ.(*types.Pointer); ok {
+		typ = p.Elem()
+	}
+	if named, ok := typ.(*types.Named); ok {
+		return named.Obj()
+	}
+	return nil
+}
+
+// recvName returns the name of the receiver type of the method d as
+// written in the source, so after any renaming
+func recvName(d *ast.FuncDecl) string {
+	typ := d.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if id, ok := typ.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// dropDecls removes the dropped functions and specs from f along with
+// the comments inside them.  Declarations left with no specs are
+// removed too.
+func dropDecls(f *ast.File, dropped map[ast.Node]bool) {
+	type span struct{ pos, end token.Pos }
+	var spans []span
+	drop := func(doc *ast.CommentGroup, node ast.Node, comment *ast.CommentGroup) {
+		s := span{node.Pos(), node.End()}
+		if doc != nil {
+			s.pos = doc.Pos()
+		}
+		if comment != nil {
+			s.end = comment.End()
+		}
+		spans = append(spans, s)
+	}
+	var decls []ast.Decl
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if dropped[d] {
+				drop(d.Doc, d, nil)
+				continue
+			}
+		case *ast.GenDecl:
+			var specs []ast.Spec
+			for _, spec := range d.Specs {
+				if !dropped[spec] {
+					specs = append(specs, spec)
+					continue
+				}
+				switch s := spec.(type) {
+				case *ast.ValueSpec:
+					drop(s.Doc, s, s.Comment)
+				case *ast.TypeSpec:
+					drop(s.Doc, s, s.Comment)
+				}
+			}
+			if len(d.Specs) > 0 && len(specs) == 0 {
+				drop(d.Doc, d, nil)
+				continue
+			}
+			d.Specs = specs
+		}
+		decls = append(decls, decl)
+	}
+	f.Decls = decls
+
+	var comments []*ast.CommentGroup
+	for _, cg := range f.Comments {
+		inside := false
+		for _, s := range spans {
+			if cg.Pos() >= s.pos && cg.End() <= s.end {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			comments = append(comments, cg)
+		}
+	}
+	f.Comments = comments
+}
@@ -0,0 +1,167 @@
+package generator
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const pruneTemplate = `package tt
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// template type Map(K, V)
+type K int
+type V int
+
+type Map struct {
+	m map[K]V
+	h *keys
+}
+
+func NewMap() *Map { return &Map{m: map[K]V{}, h: &keys{}} }
+
+func (m *Map) Get(k K) V { return m.m[k] }
+
+func (m *Map) Set(k K, v V) {
+	m.m[k] = v
+	heap.Push(m.h, k)
+}
+
+func (m *Map) Dump() { fmt.Println(m.m) }
+
+func (m *Map) String() string { return "Map" }
+
+func (m *Map) Len() int { return len(m.m) }
+
+// keys is a heap of K
+type keys []K
+
+func (h keys) Len() int            { return len(h) }
+func (h keys) Less(i, j int) bool  { return h[i] < h[j] }
+func (h keys) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keys) Push(x interface{}) { *h = append(*h, x.(K)) }
+func (h *keys) Pop() interface{}   { return nil }
+func (h keys) unused()             {}
+
+// Filter is never used
+func Filter(m *Map, f func(K) bool) *Map { return m }
+`
+
+func TestPrune(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": pruneTemplate})
+	defer cleanup()
+
+	check := func(got string, want, notWant []string) {
+		t.Helper()
+		for _, s := range want {
+			if !strings.Contains(got, s) {
+				t.Errorf("Expecting %q in\n%s", s, got)
+			}
+		}
+		for _, s := range notWant {
+			if strings.Contains(got, s) {
+				t.Errorf("Not expecting %q in\n%s", s, got)
+			}
+		}
+	}
+
+	g := New()
+	opts := Options{Dir: output, Package: "input", Instance: "IntMap(int, string)", Keep: []string{"NewIntMap", "IntMap.Set"}}
+	result, err := g.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	check(string(result.Files[0].Content), []string{
+		"func NewIntMap()",
+		") Set(k int, v string)",
+		") String() string",
+		"type keysIntMap []int",
+		") Less(i, j int) bool",
+		") Pop() interface{}",
+		// Len might be called through heap.Interface
+		"IntMap) Len()",
+		`"container/heap"`,
+	}, []string{
+		") Get(",
+		") Dump()",
+		"unused()",
+		"Filter",
+		"is never used",
+		`"fmt"`,
+	})
+
+	// Find the roots from the destination package
+	user := path.Join(output, "user.go")
+	err = ioutil.WriteFile(user, []byte("package main\n\nfunc init() {\n\t_ = NewIntMap().Get(1)\n}\n"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write %q: %v", user, err)
+	}
+	opts = Options{Dir: output, Package: "input", Instance: "IntMap(int, string)", Prune: true}
+	result, err = g.Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	check(string(result.Files[0].Content), []string{
+		"func NewIntMap()",
+		") Get(k int) string",
+		// All the methods of the kept types are kept
+		") Set(k int, v string)",
+		") unused()",
+		`"flags":["-prune"]`,
+	}, []string{
+		"Filter",
+	})
+
+	// The generated file isn't counted as a use next time
+	result, err = g.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if result.Changed() {
+		t.Errorf("Expecting no changes")
+	}
+}
+
+func TestPruneInterface(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": `package tt
+
+// template type List(A)
+type A int
+
+type List []A
+
+func (l List) Len() int           { return len(l) }
+func (l List) Less(i, j int) bool { return l[i] < l[j] }
+func (l List) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+`})
+	defer cleanup()
+	writeFile(t, path.Join(output, "main.go"), "package main\n\nimport \"sort\"\n\nfunc main() {\n\tvar l IntList\n\tsort.Sort(l)\n}\n")
+
+	result, err := New().Instantiate(context.Background(), Options{Dir: output, Package: "input", Instance: "IntList(int)", Prune: true})
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{") Len() int", ") Less(i, j int) bool", ") Swap(i, j int)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expecting %q in\n%s", want, got)
+		}
+	}
+
+	// The destination still compiles
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.LoadSyntax, Dir: output}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range pkgs[0].Errors {
+		t.Errorf("destination doesn't compile: %v", e)
+	}
+}
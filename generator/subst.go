@@ -0,0 +1,326 @@
+// Substitutes the arguments for the template parameters
+
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// replaceWithExpr arranges for the uses of old to be replaced with the
+// expression src by substitute.  Embedded fields of type old used as
+// keys in composite literals are renamed to the name of the field src
+// embeds.
+func replaceWithExpr(info *types.Info, old types.Object, src string, subs map[*ast.Ident]string) {
+	for id, obj := range info.Uses {
+		if obj == old {
+			subs[id] = src
+		} else if var_, ok := obj.(*types.Var); ok && var_.Anonymous() {
+			if named, ok := var_.Type().(*types.Named); ok && named.Obj() == old {
+				id.Name = embeddedName(src)
+			}
+		}
+	}
+}
+
+// embeddedName returns the name of the field a struct embedding the
+// type src would have
+func embeddedName(src string) string {
+	x, err := parser.ParseExpr(src)
+	if err != nil {
+		return src
+	}
+	for {
+		switch y := x.(type) {
+		case *ast.StarExpr:
+			x = y.X
+		case *ast.ParenExpr:
+			x = y.X
+		case *ast.IndexExpr:
+			x = y.X
+		case *ast.IndexListExpr:
+			x = y.X
+		case *ast.SelectorExpr:
+			return y.Sel.Name
+		case *ast.Ident:
+			return y.Name
+		default:
+			return src
+		}
+	}
+}
+
+// substitute replaces the identifiers in subs with their expressions,
+// parenthesising them where they would otherwise parse differently,
+// eg A(x) becomes (*Foo)(x) rather than *Foo(x).
+func substitute(files []*ast.File, subs map[*ast.Ident]string) error {
+	if len(subs) == 0 {
+		return nil
+	}
+	var err error
+	for _, f := range files {
+		astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+			id, ok := c.Node().(*ast.Ident)
+			if !ok {
+				return true
+			}
+			src, found := subs[id]
+			if !found {
+				return true
+			}
+			x, parseErr := parser.ParseExpr(src)
+			if parseErr != nil {
+				err = fmt.Errorf("bad argument %q: %w", src, parseErr)
+				return false
+			}
+			if y, ok := x.(*ast.Ident); ok {
+				id.Name = y.Name
+				return true
+			}
+			if _, isSel := c.Parent().(*ast.SelectorExpr); isSel && c.Name() == "Sel" {
+				// Can't put an expression here so leave the name
+				id.Name = src
+				return true
+			}
+			clearPos(x)
+			if needsParens(c.Parent(), c.Name(), x) {
+				x = &ast.ParenExpr{X: x}
+			}
+			c.Replace(x)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// needsParens returns true if x needs parentheses where it replaces the
+// field called name of parent
+func needsParens(parent ast.Node, name string, x ast.Expr) bool {
+	switch x.(type) {
+	case *ast.Ident, *ast.BasicLit, *ast.CompositeLit, *ast.FuncLit, *ast.ParenExpr,
+		*ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr, *ast.SliceExpr, *ast.CallExpr,
+		*ast.ArrayType, *ast.MapType, *ast.StructType, *ast.InterfaceType:
+		return false
+	}
+	switch parent.(type) {
+	case *ast.CallExpr:
+		return name == "Fun"
+	case *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr, *ast.SliceExpr, *ast.TypeAssertExpr:
+		return name == "X"
+	case *ast.UnaryExpr, *ast.BinaryExpr:
+		return true
+	case *ast.StarExpr:
+		// *(<-c) but *<-chan int is fine
+		_, isType := x.(*ast.ChanType)
+		return !isType
+	case *ast.ChanType:
+		// chan (<-chan int) as chan <-chan int is chan<- chan int
+		c, ok := x.(*ast.ChanType)
+		return ok && c.Dir == ast.RECV && parent.(*ast.ChanType).Dir == ast.SEND|ast.RECV
+	}
+	return false
+}
+
+// posType is the type of the position fields of the ast nodes
+var posType = reflect.TypeOf(token.NoPos)
+
+// clearPos sets all the positions in the ast x to token.NoPos so it
+// prints the same wherever it is put
+func clearPos(x ast.Node) {
+	ast.Inspect(x, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+			return true
+		}
+		v = v.Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == posType && f.CanSet() {
+				f.SetInt(int64(token.NoPos))
+			}
+		}
+		return true
+	})
+}
+
+// stubMethod is a method declared on the stub of a template parameter
+type stubMethod struct {
+	decl  *ast.FuncDecl
+	fn    *types.Func
+	param string
+}
+
+// stubFunc is the function of an argument which the calls of a stub
+// method are rewritten to use
+type stubFunc struct {
+	qualifier string // the package name it is qualified by, if any
+	name      string
+	ptr       bool // whether it takes a pointer to the argument
+}
+
+// rewriteStubMethods removes the methods declared on the stubs of the
+// parameters which have arguments.  If the type of the argument has
+// the method then calls of it are left alone.  Otherwise if the
+// package of the argument has a function of the same name taking the
+// argument as its first parameter, the calls and method expressions
+// use that instead.  It is an error if the argument has neither.
+func (t *template) rewriteStubMethods(pkg *typedPackage, methods []stubMethod) error {
+	if len(methods) == 0 {
+		return nil
+	}
+	dest, err := t.destinationPackage(nil)
+	if err != nil {
+		return err
+	}
+	info := pkg.info
+	funcs := map[*types.Func]stubFunc{}
+	dropped := map[ast.Node]bool{}
+	for _, m := range methods {
+		arg := t.qualify(t.templateArgsMap[m.param])
+		tv, err := types.Eval(token.NewFileSet(), dest, token.NoPos, arg)
+		if err != nil {
+			return fmt.Errorf("can't find method %s of %s for %s: %w", m.fn.Name(), arg, m.param, err)
+		}
+		if !tv.IsType() {
+			return fmt.Errorf("can't find method %s of %s for %s: not a type", m.fn.Name(), arg, m.param)
+		}
+		if obj, _, _ := types.LookupFieldOrMethod(tv.Type, true, dest, m.fn.Name()); obj != nil {
+			if _, ok := obj.(*types.Func); ok {
+				dropped[m.decl] = true
+				continue
+			}
+		}
+		if fn, found := argFunc(dest, arg, tv.Type, m.fn.Name()); found {
+			funcs[m.fn] = fn
+			dropped[m.decl] = true
+			continue
+		}
+		return fmt.Errorf("%s: argument %s of %s has no method %s and its package has no function %s taking it", t.Name, arg, m.param, m.fn.Name(), m.fn.Name())
+	}
+
+	// Rewrite the uses of the methods the argument has functions for
+	for _, f := range pkg.files {
+		astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+			switch x := c.Node().(type) {
+			case *ast.CallExpr:
+				sel, ok := x.Fun.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				s := info.Selections[sel]
+				if s == nil || s.Kind() != types.MethodVal {
+					return true
+				}
+				method, _ := s.Obj().(*types.Func)
+				fn, found := funcs[method]
+				if !found {
+					return true
+				}
+				if len(s.Index()) > 1 {
+					err = fmt.Errorf("can't call %s promoted from a template parameter whose argument has a function instead", sel.Sel.Name)
+					return false
+				}
+				recv := sel.X
+				_, isPtr := info.TypeOf(sel.X).Underlying().(*types.Pointer)
+				if fn.ptr && !isPtr {
+					recv = &ast.UnaryExpr{Op: token.AND, X: recv}
+				} else if isPtr && !fn.ptr {
+					recv = &ast.StarExpr{X: recv}
+				}
+				c.Replace(&ast.CallExpr{Fun: fn.expr(), Args: append([]ast.Expr{recv}, x.Args...), Ellipsis: x.Ellipsis})
+			case *ast.SelectorExpr:
+				s := info.Selections[x]
+				if _, isCall := c.Parent().(*ast.CallExpr); s == nil || isCall && c.Name() == "Fun" {
+					return true
+				}
+				method, _ := s.Obj().(*types.Func)
+				fn, found := funcs[method]
+				if !found {
+					return true
+				}
+				if s.Kind() != types.MethodExpr {
+					err = fmt.Errorf("can't use method value %s.%s of a template parameter whose argument has a function instead", types.ExprString(x.X), x.Sel.Name)
+					return false
+				}
+				c.Replace(fn.expr())
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range pkg.files {
+		dropDecls(f, dropped)
+	}
+	return nil
+}
+
+// expr returns the expression referring to the function
+func (fn stubFunc) expr() ast.Expr {
+	if fn.qualifier == "" {
+		return ast.NewIdent(fn.name)
+	}
+	return &ast.SelectorExpr{X: ast.NewIdent(fn.qualifier), Sel: ast.NewIdent(fn.name)}
+}
+
+// argFunc finds the function called name which takes the argument arg
+// of type typ, or a pointer to it, as its first parameter.  It looks
+// in the package declaring typ, which must be qualified in arg if it
+// isn't dest, or in dest if typ isn't named.
+func argFunc(dest *types.Package, arg string, typ types.Type, name string) (stubFunc, bool) {
+	scope, qualifier := dest.Scope(), ""
+	if named, ok := types.Unalias(typ).(*types.Named); ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() != dest.Path() {
+		scope = named.Obj().Pkg().Scope()
+		qualifier = argQualifier(arg, named.Obj().Name())
+		if qualifier == "" || !token.IsExported(name) {
+			return stubFunc{}, false
+		}
+	}
+	fn, ok := scope.Lookup(name).(*types.Func)
+	if !ok {
+		return stubFunc{}, false
+	}
+	params := fn.Type().(*types.Signature).Params()
+	if params.Len() == 0 {
+		return stubFunc{}, false
+	}
+	first := params.At(0).Type()
+	switch {
+	case types.AssignableTo(typ, first):
+		return stubFunc{qualifier: qualifier, name: name}, true
+	case types.AssignableTo(types.NewPointer(typ), first):
+		return stubFunc{qualifier: qualifier, name: name, ptr: true}, true
+	}
+	return stubFunc{}, false
+}
+
+// argQualifier returns the package name which qualifies the type
+// called name in arg, eg "ids" for "*ids.UserID"
+func argQualifier(arg, name string) (qualifier string) {
+	x, err := parser.ParseExpr(arg)
+	if err != nil {
+		return ""
+	}
+	ast.Inspect(x, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == name {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				qualifier = id.Name
+			}
+		}
+		return qualifier == ""
+	})
+	return qualifier
+}
@@ -0,0 +1,159 @@
+package generator
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+)
+
+const substTemplate = `package tt
+
+// template type Box(A, B, N)
+type A interface{ Len() int }
+type B func() int
+const N = 2
+
+type Box struct {
+	a A
+	b []B
+}
+
+var lenBox = A.Len
+
+func NewBox(x interface{}, f func() int) Box {
+	return Box{a: x.(A), b: []B{B(f)}}
+}
+
+func (b Box) Get() A { return A(b.a) }
+
+func Size() int { return N * 2 }
+`
+
+const stubMethodTemplate = `package tt
+
+// template type Sorted(A)
+type A int
+
+func (a A) Less(b A) bool { return a < b }
+
+func (a *A) Reset() { *a = 0 }
+
+type Sorted []A
+
+func (s Sorted) Less(i, j int) bool { return s[i].Less(s[j]) }
+
+func (s Sorted) Reset(i int) { s[i].Reset() }
+
+var less = A.Less
+`
+
+func TestSubstitute(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": substTemplate})
+	defer cleanup()
+	result, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "MyBox(*bytes.Buffer, func() int, 1+2)"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{
+		"a *bytes.Buffer",
+		"b []func() int",
+		"var lenMyBox = (*bytes.Buffer).Len",
+		"a: x.(*bytes.Buffer), b: []func() int{(func() int)(f)}",
+		"func (b MyBox) Get() *bytes.Buffer { return (*bytes.Buffer)(b.a) }",
+		"return (1 + 2) * 2",
+		`import "bytes"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expecting %q in\n%s", want, got)
+		}
+	}
+}
+
+func TestSubstituteChan(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": "package tt\n\n// template type Pipe(A)\ntype A int\n\ntype Pipe struct {\n\tin  chan A\n\tout chan<- A\n}\n"})
+	defer cleanup()
+	result, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "Pipes(<-chan int)"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{
+		"in  chan (<-chan int)",
+		"out chan<- <-chan int",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expecting %q in\n%s", want, got)
+		}
+	}
+}
+
+func TestStubMethods(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": stubMethodTemplate})
+	defer cleanup()
+	writeFile(t, path.Join(output, "version.go"), `package main
+
+type version int
+
+func (v version) Less(w version) bool { return v < w }
+
+func (v *version) Reset() { *v = 1 }
+`)
+
+	// The argument has the methods so they are called
+	result, err := New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "Versions(version)"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{
+		"return s[i].Less(s[j])",
+		"s[i].Reset()",
+		"var lessVersions = version.Less",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expecting %q in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "func (a") {
+		t.Errorf("Not expecting the stub methods in\n%s", got)
+	}
+
+	// The package has functions for the argument so they are called
+	writeFile(t, path.Join(output, "ints.go"), `package main
+
+func Less(a, b int) bool { return a < b }
+
+func Reset(p *int) { *p = 0 }
+`)
+	result, err = New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "Ints(int)"})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	got = string(result.Files[0].Content)
+	for _, want := range []string{
+		"return Less(s[i], s[j])",
+		"Reset(&s[i])",
+		"var lessInts = Less",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expecting %q in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "func (a") {
+		t.Errorf("Not expecting the stub methods in\n%s", got)
+	}
+
+	// The argument has neither
+	_, err = New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "Floats(float64)"})
+	if err == nil || !strings.Contains(err.Error(), "argument float64 of A has no method Less") {
+		t.Errorf("Expecting an error about Less, got %v", err)
+	}
+
+	// The argument can't be found
+	_, err = New().Generate(context.Background(), Options{Dir: output, Package: "input", Instance: "Missing(missing)"})
+	if err == nil || !strings.Contains(err.Error(), "can't find method Less of missing for A") {
+		t.Errorf("Expecting an error about missing, got %v", err)
+	}
+}
@@ -0,0 +1,1156 @@
+// Reads the templates and writes the substituted templates
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/imports"
+)
+
+const (
+	genHeader = "// Code generated by gotemplate. DO NOT EDIT.\n"
+)
+
+// Holds the desired template
+type template struct {
+	g               *Generator
+	ctx             context.Context
+	opts            Options
+	Package         string
+	Name            string
+	Args            []string
+	argNames        []string // names of the named arguments, "" if positional
+	NewPackage      string
+	Dir             string
+	definition      string // template chosen with "Name = Definition(...)"
+	templateName    string
+	templateNames   []string // all the templates defined in the package
+	templateArgs    []string
+	templateArgsMap map[string]string
+	defaults        map[string]string // defaults of the parameters, "Param = Default"
+	defaulted       map[string]bool   // parameters which took their defaults
+	allParams       map[string]bool   // the parameters of all the templates
+	importNames     map[string]string // names of the packages the arguments import by path
+	mappings        map[types.Object]string
+	formatFuncs     map[string]string
+	files           []File
+	provenance      *Provenance
+}
+
+// init the template instantiation
+func newTemplate(ctx context.Context, g *Generator, opts Options) (*template, error) {
+	t := &template{
+		g:               g,
+		ctx:             ctx,
+		opts:            opts,
+		Package:         opts.Package,
+		Dir:             opts.Dir,
+		mappings:        make(map[types.Object]string),
+		templateArgsMap: make(map[string]string),
+		defaulted:       make(map[string]bool),
+		formatFuncs:     make(map[string]string),
+	}
+	var err error
+	instance := opts.Instance
+	chosen := matchChosenDefinition.FindStringSubmatch(instance)
+	if chosen != nil {
+		instance = chosen[2]
+	}
+	t.Name, t.Args, t.argNames, err = t.parseTemplateAndArgs(instance)
+	if err != nil {
+		return nil, err
+	}
+	if chosen != nil {
+		t.definition, t.Name = t.Name, chosen[1]
+	}
+	t.NewPackage, err = outputPackageName(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Log if Verbose set
+func (t *template) debugf(format string, args ...interface{}) {
+	t.opts.debugf(format, args...)
+}
+
+// filename makes the instance name into a file name
+func (t *template) filename(n string) string {
+	if t.opts.RawName {
+		return n
+	}
+	return snakeCase(n)
+}
+
+// Add a mapping for identifier
+func (t *template) addMapping(object types.Object, name string) {
+	t.mappings[object] = t.instanceName(name, t.Name)
+}
+
+// instanceName makes the name of the top level identifier name in
+// the instance called instance
+func (t *template) instanceName(name, instance string) string {
+	replacementName := ""
+	if !strings.Contains(name, t.templateName) {
+		// If name doesn't contain template name then just prefix it
+		innerName := strings.ToUpper(instance[:1]) + instance[1:]
+		replacementName = name + innerName
+		t.debugf("Top level definition '%s' doesn't contain template name '%s', using '%s'", name, t.templateName, replacementName)
+	} else {
+		// make sure the new identifier will follow
+		// Go casing style (newMySet not newmySet).
+		innerName := instance
+		if strings.Index(name, t.templateName) != 0 {
+			innerName = strings.ToUpper(innerName[:1]) + innerName[1:]
+		}
+		replacementName = strings.Replace(name, t.templateName, innerName, 1)
+	}
+	// If new template name is not public then make sure
+	// the exported name is not public too
+	if !ast.IsExported(instance) && ast.IsExported(replacementName) {
+		replacementName = strings.ToLower(replacementName[:1]) + replacementName[1:]
+	}
+	return replacementName
+}
+
+// errExpectingCall is wrapped in a ParseError when the instantiation
+// isn't of the form Identifier(...)
+var errExpectingCall = errors.New("expecting Identifier(...)")
+
+// errPositionalAfterNamed is wrapped in a ParseError when a
+// positional argument follows a named one
+var errPositionalAfterNamed = errors.New("positional argument after named argument")
+
+// Parse the arguments string Template(A, B, C).  The arguments may be
+// named, as in Template(A=int, B=string, C=x), in which case names
+// holds the names, or "" for the positional arguments.
+func (t *template) parseTemplateAndArgs(s string) (name string, args []string, names []string, err error) {
+	call, names, err := splitNamedArgs(s)
+	if err != nil {
+		return "", nil, nil, &ParseError{Input: s, Err: err}
+	}
+	expr, err := parser.ParseExpr(call)
+	if err != nil {
+		return "", nil, nil, &ParseError{Input: s, Err: err}
+	}
+	t.debugf("expr = %#v\n", expr)
+	callExpr, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return "", nil, nil, &ParseError{Input: s, Err: errExpectingCall}
+	}
+	t.debugf("fun = %#v", callExpr.Fun)
+	fn, ok := callExpr.Fun.(*ast.Ident)
+	if !ok {
+		return "", nil, nil, &ParseError{Input: s, Err: errExpectingCall}
+	}
+	name = fn.Name
+	for i, arg := range callExpr.Args {
+		var buf bytes.Buffer
+		t.debugf("arg[%d] = %#v", i, arg)
+		err = format.Node(&buf, token.NewFileSet(), arg)
+		if err != nil {
+			return "", nil, nil, &ParseError{Input: s, Err: err}
+		}
+		s := buf.String()
+		t.debugf("parsed = %q", s)
+		args = append(args, s)
+	}
+	return name, args, names, nil
+}
+
+// splitNamedArgs takes the names off the named arguments of the call
+// s, eg "MyHeap(A=int, Less=myLess)", returning the call with just the
+// values, eg "MyHeap(int, myLess)", and the names.  names is nil if
+// no argument is named.
+func splitNamedArgs(s string) (call string, names []string, err error) {
+	s = strings.TrimSpace(s)
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		// Leave the parser to complain
+		return s, nil, nil
+	}
+	inner := s[open+1 : len(s)-1]
+	var args []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, inner[start:i])
+			start = i + 1
+		}
+	}
+	if last := inner[start:]; strings.TrimSpace(last) != "" {
+		args = append(args, last)
+	}
+	named := false
+	for i, arg := range args {
+		m := matchNamedArg.FindStringSubmatch(arg)
+		if m == nil {
+			if named {
+				return "", nil, errPositionalAfterNamed
+			}
+			names = append(names, "")
+			continue
+		}
+		named = true
+		names = append(names, m[1])
+		args[i] = m[2]
+	}
+	if !named {
+		return s, nil, nil
+	}
+	return s[:open+1] + strings.Join(args, ", ") + ")", names, nil
+}
+
+var (
+	matchNamedArg         = regexp.MustCompile(`(?s)^\s*([\pL_][\pL\pN_]*)\s*=([^=].*)$`)
+	matchChosenDefinition = regexp.MustCompile(`(?s)^\s*(\w+)\s*=\s*(\w+\s*\(.*)$`)
+	matchTemplateType     = regexp.MustCompile(`^//\s*template\s+type\s+(\w+\s*.*?)\s*$`)
+	matchFirstCap         = regexp.MustCompile("(.)([A-Z][a-z]+)")
+	matchAllCap           = regexp.MustCompile("([a-z0-9])([A-Z])")
+	matchFormat           = regexp.MustCompile(`^//\s*template\s+format\s*$`)
+)
+
+func snakeCase(str string) string {
+	snake := matchFirstCap.ReplaceAllString(str, "${1}_${2}")
+	snake = matchAllCap.ReplaceAllString(snake, "${1}_${2}")
+	return strings.ToLower(snake)
+}
+
+// findTemplateDefinition looks for the "// template type" comments
+// which may be in any of the files of the package.  If there is more
+// than one then the instance must choose one.
+func (t *template) findTemplateDefinition(fset *token.FileSet, files []*ast.File) error {
+	// Inspect the comments
+	args := map[string][]string{}
+	defaults := map[string]map[string]string{}
+	definedIn := map[string]string{}
+	t.templateNames = nil
+	t.allParams = map[string]bool{}
+	for _, f := range files {
+		fileName := fset.Position(f.Pos()).Filename
+		for _, cg := range f.Comments {
+			for _, x := range cg.List {
+				matches := matchTemplateType.FindStringSubmatch(x.Text)
+				if matches != nil {
+					name, templateArgs, names, err := t.parseTemplateAndArgs(matches[1])
+					if err != nil {
+						return err
+					}
+					if _, found := args[name]; found {
+						return fmt.Errorf("found multiple template definitions of %s in %s and %s", name, definedIn[name], fileName)
+					}
+					args[name], defaults[name] = templateParams(templateArgs, names)
+					for _, param := range args[name] {
+						t.allParams[param] = true
+					}
+					definedIn[name] = fileName
+					t.templateNames = append(t.templateNames, name)
+				}
+			}
+		}
+	}
+	if len(t.templateNames) == 0 {
+		return &MissingDefinitionError{Package: t.Package}
+	}
+	t.templateName = t.definition
+	if t.templateName == "" {
+		if len(t.templateNames) > 1 {
+			return fmt.Errorf("%s defines templates %s - choose one with %s = Template(...)", t.Package, strings.Join(t.templateNames, ", "), t.Name)
+		}
+		t.templateName = t.templateNames[0]
+	}
+	var found bool
+	t.templateArgs, found = args[t.templateName]
+	if !found {
+		return &MissingDefinitionError{Package: t.Package, Name: t.templateName}
+	}
+	t.defaults = defaults[t.templateName]
+	if err := t.resolveArgs(files); err != nil {
+		return err
+	}
+	for i, to := range t.Args {
+		param := t.templateArgs[i]
+		if to == "" {
+			to = t.defaults[param]
+			if to == param {
+				// Keep the stub as the default
+				continue
+			}
+			if to != "" {
+				t.defaulted[param] = true
+			}
+		}
+		if to != "" {
+			t.templateArgsMap[param] = to
+		}
+	}
+	t.debugf("templateName = %v, templateArgs = %v found in %s", t.templateName, t.templateArgs, definedIn[t.templateName])
+	return nil
+}
+
+// templateParams returns the parameters of the template comment whose
+// arguments are args and names, as parsed by parseTemplateAndArgs,
+// along with the defaults of those declared as Param = Default
+func templateParams(args, names []string) (params []string, defaults map[string]string) {
+	defaults = map[string]string{}
+	for i, arg := range args {
+		if names == nil || names[i] == "" {
+			params = append(params, arg)
+			continue
+		}
+		params = append(params, names[i])
+		defaults[names[i]] = arg
+	}
+	return params, defaults
+}
+
+// resolveArgs puts the arguments in the order of the template
+// parameters, matching the named arguments to the parameters by name.
+// Parameters left out which may take a default are set to "".
+func (t *template) resolveArgs(files []*ast.File) error {
+	index := map[string]int{}
+	for i, param := range t.templateArgs {
+		index[param] = i
+	}
+	args := make([]string, len(t.templateArgs))
+	given := make([]bool, len(t.templateArgs))
+	for i, arg := range t.Args {
+		j := i
+		if t.argNames != nil && t.argNames[i] != "" {
+			name := t.argNames[i]
+			var found bool
+			j, found = index[name]
+			if !found {
+				return &ArgumentError{Template: t.templateName, Name: name,
+					Reason: "no such parameter, expecting one of " + strings.Join(t.templateArgs, ", ")}
+			}
+		} else if i >= len(args) {
+			return &ArityError{Template: t.templateName, Want: len(t.templateArgs), Got: len(t.Args)}
+		}
+		if given[j] {
+			return &ArgumentError{Template: t.templateName, Name: t.templateArgs[j], Reason: "given more than once"}
+		}
+		given[j] = true
+		args[j] = arg
+	}
+	for j, ok := range given {
+		param := t.templateArgs[j]
+		if _, found := t.defaults[param]; ok || found || isStubDefault(files, param) {
+			continue
+		}
+		if t.argNames == nil {
+			return &ArityError{Template: t.templateName, Want: len(t.templateArgs), Got: len(t.Args)}
+		}
+		return &ArgumentError{Template: t.templateName, Name: param, Reason: "missing"}
+	}
+	t.Args = args
+	return nil
+}
+
+// givenArgs returns the arguments which were given in the order of the
+// parameters.  Those following a parameter which took its default are
+// named.
+func (t *template) givenArgs() []string {
+	var args []string
+	named := false
+	for i, arg := range t.Args {
+		if arg == "" {
+			named = true
+			continue
+		}
+		if named {
+			arg = t.templateArgs[i] + "=" + arg
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// isStubDefault returns true if the stub of the parameter called name
+// in files is a func, const or var, so can be kept as the default
+// when no argument is given
+func isStubDefault(files []*ast.File, name string) bool {
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.Name == name {
+					return true
+				}
+			case *ast.GenDecl:
+				if d.Tok != token.CONST && d.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range d.Specs {
+					for _, id := range spec.(*ast.ValueSpec).Names {
+						if id.Name == name {
+							return true
+						}
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Parses a file into a Fileset and Ast
+func parseFile(path string, src interface{}) (*token.FileSet, *ast.File, error) {
+	fset := token.NewFileSet() // positions are relative to fset
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+	return fset, f, nil
+}
+
+// Replace the identifers in all the files described by info
+func replaceIdentifier(info *types.Info, old types.Object, new string) {
+	for id, obj := range info.Defs {
+		if obj == old {
+			id.Name = new
+		}
+	}
+	for id, obj := range info.Uses {
+		if obj == old {
+			id.Name = new
+		} else {
+			if var_, ok := obj.(*types.Var); ok && var_.Anonymous() {
+				// This is an anonymous field in composite literal
+				// We should replace it if we replace a type it represents
+				if named, ok := var_.Type().(*types.Named); ok && named.Obj() == old {
+					id.Name = new
+				}
+			}
+		}
+	}
+}
+
+// isTestDecl check ast.Decl is testing func by containing testing parameter
+func isTestDecl(decl ast.Decl) (is bool) {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Type == nil || d.Type.Params == nil {
+			return
+		}
+		if len(d.Type.Params.List) == 0 {
+			return
+		}
+		for _, l := range d.Type.Params.List {
+			testingStar, ok0 := l.Type.(*ast.StarExpr)
+			if !ok0 {
+				return
+			}
+			selExpr, ok1 := testingStar.X.(*ast.SelectorExpr)
+			if !ok1 {
+				return
+			}
+			ident, ok2 := selExpr.X.(*ast.Ident)
+			if !ok2 {
+				return
+			}
+			is = ident.Name == "testing"
+		}
+	}
+	return
+}
+
+// render formats f, fixes up its imports and applies the format
+// funcs, returning the source without the generated header
+func (t *template) render(fset *token.FileSet, f *ast.File, outputFileName string, isTest bool) ([]byte, error) {
+	b := new(bytes.Buffer)
+	if err := format.Node(b, fset, f); err != nil {
+		return nil, fmt.Errorf("failed to format output: %w", err)
+	}
+	bts, err := imports.Process(outputFileName, b.Bytes(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fix imports: %w", err)
+	}
+
+	var ss = string(bts)
+	if !isTest && len(t.formatFuncs) > 0 {
+		for k, v := range t.formatFuncs {
+			ss = strings.ReplaceAll(ss, k, v)
+		}
+	}
+	return []byte(ss), nil
+}
+
+// output adds the generated header to src, formats it and adds it to
+// the files to be written as outputFileName
+func (t *template) output(outputFileName string, src []byte) error {
+	header, err := t.provenance.header()
+	if err != nil {
+		return err
+	}
+	fset, f, err := parseFile(outputFileName, header+string(src))
+	if err != nil {
+		return err
+	}
+
+	b := new(bytes.Buffer)
+	if err := format.Node(b, fset, f); err != nil {
+		return fmt.Errorf("failed to format output: %w", err)
+	}
+	bts, err := imports.Process(outputFileName, b.Bytes(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot fix imports: %w", err)
+	}
+	t.files = append(t.files, File{Path: outputFileName, Content: bts})
+	return nil
+}
+
+// mergeSources joins the rendered sources of several template files
+// into one file with a single package clause and import block
+func mergeSources(srcs [][]byte) ([]byte, error) {
+	if len(srcs) == 1 {
+		return srcs[0], nil
+	}
+	var (
+		head    []byte
+		imps    []string
+		seen    = map[string]bool{}
+		bodies  bytes.Buffer
+		pkgName string
+	)
+	for i, src := range srcs {
+		fset, f, err := parseFile("", src)
+		if err != nil {
+			return nil, err
+		}
+		offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+		if i == 0 {
+			head = src[:offset(f.Package)]
+			pkgName = f.Name.Name
+		}
+		end := f.Name.End()
+		for _, decl := range f.Decls {
+			d, ok := decl.(*ast.GenDecl)
+			if !ok || d.Tok != token.IMPORT {
+				break
+			}
+			for _, spec := range d.Specs {
+				imp := string(src[offset(spec.Pos()):offset(spec.End())])
+				if !seen[imp] {
+					seen[imp] = true
+					imps = append(imps, imp)
+				}
+			}
+			end = d.End()
+		}
+		bodies.Write(bytes.TrimSpace(src[offset(end):]))
+		bodies.WriteString("\n\n")
+	}
+
+	b := new(bytes.Buffer)
+	b.Write(head)
+	fmt.Fprintf(b, "package %s\n\n", pkgName)
+	if len(imps) > 0 {
+		fmt.Fprintf(b, "import (\n\t%s\n)\n\n", strings.Join(imps, "\n\t"))
+	}
+	b.Write(bodies.Bytes())
+	return b.Bytes(), nil
+}
+
+var testingsMapping = map[string]struct{}{
+	"\"testing\"": {},
+	"\"github.com/smartystreets/goconvey/convey\"": {},
+}
+
+func isTestImport(imp *ast.ImportSpec) (is bool) {
+	if imp == nil || imp.Path == nil {
+		return
+	}
+	if _, ok := testingsMapping[imp.Path.Value]; ok {
+		is = true
+		return
+	}
+	return
+}
+
+func arrangeDecl(decl ast.Decl) (testDecl, genDecl ast.Decl) {
+	genDecl = decl
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Type == nil || d.Type.Params == nil {
+			return
+		}
+		if len(d.Type.Params.List) == 0 {
+			return
+		}
+		for _, l := range d.Type.Params.List {
+			testingStar, ok0 := l.Type.(*ast.StarExpr)
+			if !ok0 {
+				return
+			}
+			selExpr, ok1 := testingStar.X.(*ast.SelectorExpr)
+			if !ok1 {
+				return
+			}
+			ident, ok2 := selExpr.X.(*ast.Ident)
+			if !ok2 {
+				return
+			}
+			if ident.Name == "testing" {
+				testDecl = decl
+				genDecl = nil
+			}
+		}
+	case *ast.GenDecl:
+		switch d.Tok {
+		case token.IMPORT:
+			var is, tis []ast.Spec
+			for _, im := range d.Specs {
+				if isTestImport(im.(*ast.ImportSpec)) {
+					tis = append(tis, im)
+				} else {
+					is = append(is, im)
+				}
+			}
+			if len(tis) > 0 {
+				cd := *d
+				cd.Specs = tis
+				d.Specs = is
+				testDecl = &cd
+				genDecl = d
+			}
+			return
+		}
+	}
+	return
+}
+
+// Parses the template package, which may be made of several files
+func (t *template) parse() error {
+	pkg, err := t.g.load(t.ctx, t.Dir, t.Package)
+	if err != nil {
+		return err
+	}
+
+	info := pkg.info
+	fset := pkg.fset
+	files := pkg.files
+
+	generic := isGenericTemplate(pkg)
+	if generic {
+		err = t.findGenericDefinition(pkg)
+	} else {
+		err = t.findTemplateDefinition(fset, files)
+	}
+	if err != nil {
+		return err
+	}
+	if err := t.resolveImports(pkg); err != nil {
+		return err
+	}
+	if err := t.checkGoVersion(pkg); err != nil {
+		return err
+	}
+	constraints, err := t.findConstraints(files, t.allParams)
+	if err != nil {
+		return err
+	}
+	if err := t.checkConstraints(pkg, constraints); err != nil {
+		return err
+	}
+	if generic {
+		if err := t.checkTypeParams(pkg); err != nil {
+			return err
+		}
+	}
+	t.provenance = &Provenance{
+		Template:   t.templatePath(),
+		Version:    pkg.version,
+		Hash:       pkg.hash,
+		Instance:   t.Name,
+		Definition: t.definition,
+		Args:       t.givenArgs(),
+		Flags:      t.opts.flags(),
+		Generator:  Version,
+	}
+
+	duplicate, err := t.findDuplicate()
+	if err != nil {
+		return err
+	}
+	if duplicate != "" && !t.opts.Alias {
+		t.opts.Logf("%s: %s has the same template and arguments as %s - use -alias to declare aliases instead", t.Package, t.Name, duplicate)
+	}
+	if generic {
+		if err := t.monomorphize(pkg); err != nil {
+			return err
+		}
+	} else if len(t.templateNames) > 1 {
+		t.pruneDecls(pkg.types.Scope(), info, files)
+	}
+
+	// debugf("Decls = %#v", f.Decls)
+	// Find names which need to be adjusted
+	namesToMangle := map[types.Object]string{}
+	var stubMethods []stubMethod
+	var hasTestingFunc bool
+	for _, f := range files {
+		// The comments of the removed parameter declarations
+		removedComments := map[*ast.CommentGroup]bool{}
+		newDecls := []ast.Decl{}
+		for _, decl := range f.Decls {
+			remove := false
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				// A general definition
+				switch d.Tok {
+				case token.IMPORT:
+					// Ignore imports
+				case token.CONST, token.VAR:
+					// Find and remove identifiers found in template
+					// params
+					emptySpecs := []int{}
+					for i, spec := range d.Specs {
+						namesToRemove := []int{}
+						v := spec.(*ast.ValueSpec)
+						for j, name := range v.Names {
+							t.debugf("VAR or CONST %v", name.Name)
+							def := info.Defs[name]
+							if _, ok := t.templateArgsMap[name.Name]; ok {
+								namesToRemove = append(namesToRemove, j)
+								t.mappings[def] = t.templateArgsMap[name.Name]
+							} else {
+								namesToMangle[def] = name.Name
+							}
+						}
+						// Shuffle the names to remove out of v.Names and v.Values
+						for i := len(namesToRemove) - 1; i >= 0; i-- {
+							p := namesToRemove[i]
+							v.Names = append(v.Names[:p], v.Names[p+1:]...)
+							v.Values = append(v.Values[:p], v.Values[p+1:]...)
+						}
+						// If empty then add to slice to remove later
+						if len(v.Names) == 0 {
+							emptySpecs = append(emptySpecs, i)
+							removedComments[v.Doc] = true
+							removedComments[v.Comment] = true
+						}
+					}
+					// Remove now-empty specs
+					for i := len(emptySpecs) - 1; i >= 0; i-- {
+						p := emptySpecs[i]
+						d.Specs = append(d.Specs[:p], d.Specs[p+1:]...)
+					}
+					remove = len(d.Specs) == 0
+				case token.TYPE:
+					namesToRemove := []int{}
+					for i, spec := range d.Specs {
+						typeSpec := spec.(*ast.TypeSpec)
+						t.debugf("Type %v", typeSpec.Name.Name)
+						// Remove type A if it is a template definition
+						def := info.Defs[typeSpec.Name]
+						if _, ok := t.templateArgsMap[typeSpec.Name.Name]; ok {
+							namesToRemove = append(namesToRemove, i)
+							removedComments[typeSpec.Doc] = true
+							removedComments[typeSpec.Comment] = true
+							t.mappings[def] = t.templateArgsMap[typeSpec.Name.Name]
+						} else {
+							namesToMangle[def] = typeSpec.Name.Name
+						}
+					}
+					for i := len(namesToRemove) - 1; i >= 0; i-- {
+						p := namesToRemove[i]
+						d.Specs = append(d.Specs[:p], d.Specs[p+1:]...)
+					}
+					remove = len(d.Specs) == 0
+				default:
+					t.opts.Logf("Unknown type %s", d.Tok)
+				}
+				t.debugf("GenDecl = %#v", d)
+			case *ast.FuncDecl:
+				// A function definition
+				if d.Recv != nil {
+					// Has receiver so is a method - collect the
+					// methods of the template parameters
+					if name := recvName(d); name != "" {
+						if _, ok := t.templateArgsMap[name]; ok {
+							if fn, ok := info.Defs[d.Name].(*types.Func); ok {
+								stubMethods = append(stubMethods, stubMethod{decl: d, fn: fn, param: name})
+							}
+						}
+					}
+				} else if d.Name.Name == "init" {
+					// Init function - ignore this function
+				} else {
+					if !hasTestingFunc && isTestDecl(d) {
+						hasTestingFunc = true
+					}
+					//debugf("FuncDecl = %#v", d)
+					t.debugf("FuncDecl = %s", d.Name.Name)
+					def := info.Defs[d.Name]
+					// Remove func A() if it is a template definition
+					if _, ok := t.templateArgsMap[d.Name.Name]; ok {
+						remove = true
+						removedComments[d.Doc] = true
+						t.mappings[def] = t.templateArgsMap[d.Name.Name]
+					} else {
+						namesToMangle[def] = d.Name.Name
+					}
+				}
+			default:
+				return fmt.Errorf("unknown Decl %#v", decl)
+			}
+			if !remove {
+				newDecls = append(newDecls, decl)
+			} else if d, ok := decl.(*ast.GenDecl); ok {
+				removedComments[d.Doc] = true
+			}
+		}
+
+		// Remove the stub type definitions "type A int" from the file
+		// along with their comments
+		f.Decls = newDecls
+		comments := f.Comments[:0]
+		for _, cg := range f.Comments {
+			if !removedComments[cg] {
+				comments = append(comments, cg)
+			}
+		}
+		f.Comments = comments
+	}
+	t.debugf("Names to mangle = %#v", namesToMangle)
+
+	found := false
+	for obj, name := range namesToMangle {
+		if name == t.templateName {
+			found = true
+			t.addMapping(obj, name)
+		} else if _, found := t.mappings[obj]; !found {
+			t.addMapping(obj, name)
+		}
+
+	}
+	if !found {
+		return &MissingDefinitionError{Package: t.Package, Name: t.templateName}
+	}
+	// Defaults which are declarations of the template are used in
+	// place of the parameter so take their new names
+	scope := pkg.types.Scope()
+	for param := range t.defaulted {
+		paramObj, defaultObj := scope.Lookup(param), scope.Lookup(t.templateArgsMap[param])
+		name, found := t.mappings[defaultObj]
+		if paramObj == nil || !found {
+			continue
+		}
+		for id, obj := range info.Uses {
+			if obj == paramObj {
+				info.Uses[id] = defaultObj
+			}
+		}
+		delete(t.mappings, paramObj)
+		t.templateArgsMap[param] = name
+	}
+	t.debugf("mappings = %#v", t.mappings)
+
+	if err := t.rewriteStubMethods(pkg, stubMethods); err != nil {
+		return err
+	}
+
+	// Replace the identifiers, substituting the arguments which
+	// aren't identifiers as expressions
+	subs := map[*ast.Ident]string{}
+	for obj, replacement := range t.mappings {
+		if token.IsIdentifier(replacement) {
+			replaceIdentifier(info, obj, replacement)
+		} else {
+			replaceWithExpr(info, obj, replacement, subs)
+		}
+	}
+	if err := substitute(files, subs); err != nil {
+		return err
+	}
+	t.addImports(fset, files)
+
+	if t.opts.Prune || len(t.opts.Keep) > 0 {
+		if err := t.pruneUnused(pkg.types.Scope(), info, files); err != nil {
+			return err
+		}
+	}
+
+	if err := t.checkCollisions(fset, info, files); err != nil {
+		return err
+	}
+
+	if duplicate != "" && t.opts.Alias {
+		return t.outputAlias(duplicate, fset, info, files, namesToMangle)
+	}
+	rewriteComments(info, files, namesToMangle, t.templateArgsMap, t.commentRenames(info, files, namesToMangle))
+	if t.opts.Generic != "" {
+		return t.outputGeneric(pkg, namesToMangle)
+	}
+
+	key, err := instanceKey(t.opts.OutDir, t.provenance)
+	if err != nil {
+		return err
+	}
+	if _, found := t.g.instances[key]; !found {
+		t.g.instances[key] = t.Name
+	}
+
+	// Separate out the test functions of each file first so that the
+	// format funcs are known before any file is rendered
+	testDecls := make([][]ast.Decl, len(files))
+	for i, f := range files {
+		// Change the package to the local package name
+		f.Name.Name = t.NewPackage
+		if hasTestingFunc {
+			testDecls[i], err = t.arrangeFile(f)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var srcs, testSrcs [][]byte
+	var names []string
+	for i, f := range files {
+		name := fset.Position(f.Pos()).Filename
+		names = append(names, strings.TrimSuffix(path.Base(name), ".go"))
+		src, err := t.render(fset, f, t.outputName(".go"), false)
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, src)
+		var testSrc []byte
+		if t.opts.Test && len(testDecls[i]) > 0 {
+			// remove other comments
+			f.Comments = nil
+			f.Decls = testDecls[i]
+			testSrc, err = t.render(fset, f, t.outputName("_test.go"), true)
+			if err != nil {
+				return err
+			}
+		}
+		testSrcs = append(testSrcs, testSrc)
+	}
+
+	if t.opts.Split && len(files) > 1 {
+		for i := range files {
+			if err := t.output(t.outputName("_"+t.filename(names[i])+".go"), srcs[i]); err != nil {
+				return err
+			}
+			if testSrcs[i] != nil {
+				if err := t.output(t.outputName("_"+t.filename(names[i])+"_test.go"), testSrcs[i]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	src, err := mergeSources(srcs)
+	if err != nil {
+		return err
+	}
+	if err := t.output(t.outputName(".go"), src); err != nil {
+		return err
+	}
+	var tests [][]byte
+	for _, src := range testSrcs {
+		if src != nil {
+			tests = append(tests, src)
+		}
+	}
+	if len(tests) > 0 {
+		src, err := mergeSources(tests)
+		if err != nil {
+			return err
+		}
+		if err := t.output(t.outputName("_test.go"), src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templatePath returns the template package as it should be found
+// from the output directory
+func (t *template) templatePath() string {
+	if !isLocal(t.Package) || filepath.IsAbs(t.Package) || t.opts.OutDir == t.Dir {
+		return t.Package
+	}
+	rel, err := filepath.Rel(t.opts.OutDir, filepath.Join(t.Dir, t.Package))
+	if err != nil {
+		return t.Package
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel
+}
+
+// outputName makes the path of an output file from the OutFmt option
+// and the instance name followed by suffix
+func (t *template) outputName(suffix string) string {
+	name := fmt.Sprintf(t.opts.OutFmt+strings.ReplaceAll(suffix, "%", "%%"), t.filename(t.Name))
+	return filepath.Join(t.opts.OutDir, name)
+}
+
+// arrangeFile removes the testing functions from f, returning them
+func (t *template) arrangeFile(f *ast.File) ([]ast.Decl, error) {
+	var decls, testDecls []ast.Decl
+	var testComments []*ast.CommentGroup
+	var getComment = func(decl ast.Decl) {
+		switch v := decl.(type) {
+		case *ast.GenDecl:
+			if v.Doc != nil {
+				testComments = append(testComments, v.Doc)
+			}
+		case *ast.FuncDecl:
+			if v.Doc != nil {
+				testComments = append(testComments, v.Doc)
+			}
+		}
+	}
+	for _, decl := range f.Decls {
+		testDecl, genDecl := arrangeDecl(decl)
+		if testDecl != nil {
+			testDecls = append(testDecls, testDecl)
+			getComment(testDecl)
+		}
+		if genDecl != nil {
+			if err := t.reviseIfSpecialDecl(genDecl); err != nil {
+				return nil, err
+			}
+			decls = append(decls, genDecl)
+		}
+	}
+	// remove testing function
+	f.Decls = decls
+
+	// remove test comments
+	if len(testComments) > 0 {
+		var comments = make([]*ast.CommentGroup, 0, len(f.Comments))
+		for _, j := range f.Comments {
+			var remove bool
+			for _, td := range testComments {
+				if j == td {
+					remove = true
+					break
+				}
+			}
+			if !remove {
+				comments = append(comments, j)
+			}
+		}
+		if len(comments) != len(f.Comments) {
+			f.Comments = comments
+		}
+	}
+
+	return testDecls, nil
+}
+
+func (t *template) reviseIfSpecialDecl(decl ast.Decl) error {
+	switch v := decl.(type) {
+	case *ast.GenDecl:
+		if v.Doc == nil || len(v.Doc.List) == 0 {
+			return nil
+		}
+		if len(v.Specs) == 0 || v.Specs[0] == nil {
+			return nil
+		}
+		if _, ok := v.Specs[0].(*ast.ValueSpec); !ok || v.Specs[0].(*ast.ValueSpec).Type == nil {
+			return nil
+		}
+		if _, ok := v.Specs[0].(*ast.ValueSpec).Type.(*ast.FuncType); !ok || v.Specs[0].(*ast.ValueSpec).Type.(*ast.FuncType).Results == nil {
+			return nil
+		}
+		if len(v.Specs[0].(*ast.ValueSpec).Type.(*ast.FuncType).Results.List) == 0 ||
+			v.Specs[0].(*ast.ValueSpec).Type.(*ast.FuncType).Results.List[0].Type == nil {
+			return nil
+		}
+		if len(v.Specs) == 0 || len(v.Specs[0].(*ast.ValueSpec).Type.(*ast.FuncType).Results.List) == 0 {
+			return nil
+		}
+		name := v.Specs[0].(*ast.ValueSpec).Type.(*ast.FuncType).Results.List[0].Type.(*ast.Ident).Name
+		for _, cm := range v.Doc.List {
+			if matches := matchFormat.FindStringSubmatch(cm.Text); len(matches) > 0 {
+				if formatFunc := getFormatFunc(name); len(formatFunc) > 0 {
+					b := new(bytes.Buffer)
+					err := format.Node(b, token.NewFileSet(), v.Specs[0].(*ast.ValueSpec))
+					if err != nil {
+						return fmt.Errorf("format error for template type '%s': %w", t.templateName, err)
+					}
+					txt := b.String()
+					t.formatFuncs[txt] = strings.Split(txt, " ")[0] + " = " + formatFunc
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Instantiate the template package
+func (t *template) instantiate() error {
+	t.debugf("Substituting %q with %s(%s) into package %s", t.Package, t.Name, strings.Join(t.Args, ","), t.NewPackage)
+
+	return t.parse()
+}
+
+// findInputFiles finds the go files of the template package
+func (t *template) findInputFiles() ([]string, error) {
+	lp, err := t.g.loaded(t.ctx, t.Dir, t.Package)
+	if err != nil {
+		return nil, err
+	}
+	t.debugf("Go files = %#v", lp.pkg.CompiledGoFiles)
+	return lp.pkg.CompiledGoFiles, nil
+}
+
+// outputPaths returns the paths of all the files the instantiation
+// could write without instantiating it
+func (t *template) outputPaths() ([]string, error) {
+	if !t.opts.Split || t.opts.Generic != "" {
+		return []string{t.outputName(".go"), t.outputName("_test.go")}, nil
+	}
+	inputFiles, err := t.findInputFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(inputFiles) == 1 {
+		return []string{t.outputName(".go"), t.outputName("_test.go")}, nil
+	}
+	var paths []string
+	for _, name := range inputFiles {
+		name = t.filename(strings.TrimSuffix(path.Base(name), ".go"))
+		paths = append(paths, t.outputName("_"+name+".go"), t.outputName("_"+name+"_test.go"))
+	}
+	return paths, nil
+}
@@ -0,0 +1,974 @@
+// Tests for template
+
+package generator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+type TestTemplate struct {
+	title    string
+	args     string
+	pkg      string
+	in       string
+	outName  string
+	out      string
+	inFiles  map[string]string // extra template files by name
+	dest     map[string]string // destination package files by name
+	outFiles map[string]string // extra expected outputs by name
+	split    bool
+}
+
+const basicTest = `package tt
+
+// template type Set(A)
+type A int
+
+func init() {}
+
+type Set struct { a A }
+func NewSet(a A) A { return A(0) }
+func NewSizedSet(a A) A { return A(1) }
+func UtilityFunc1() {}
+func utilityFunc() {}
+func (a A) f0() {}
+func (a *A) F1() {}
+
+type N struct{}
+type M struct{ N }
+type K struct{ N int }
+
+func (a *A) N() {}
+func (a *A) O() { a.N() }
+func f2() M     { return M{N: N{}} }
+func f3() K     { return K{N: 0} }
+func f4() N     { return f2().N }
+func f5() int   { return f3().N }
+func f6() {
+	N := 0
+	_ = N
+}
+
+var AVar1 int
+var aVar2 int
+var (
+	AVar3 int
+	aVar4 int
+)
+`
+
+var tests = []TestTemplate{
+	{
+		title:   "Simple test public",
+		args:    "MySet(int)",
+		pkg:     "main",
+		in:      basicTest,
+		dest:    map[string]string{"stubs.go": "package main\n\nfunc f0(a int)  {}\nfunc F1(a *int) {}\nfunc N(a *int)  {}\nfunc O(a *int)  {}\n"},
+		outName: "gotemplate_MySet.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+func init() {}
+
+type MySet struct{ a int }
+
+func NewMySet(a int) int      { return int(0) }
+func NewSizedMySet(a int) int { return int(1) }
+func UtilityFunc1MySet()      {}
+func utilityFuncMySet()       {}
+
+type NMySet struct{}
+type MMySet struct{ NMySet }
+type KMySet struct{ N int }
+
+func f2MySet() MMySet { return MMySet{NMySet: NMySet{}} }
+func f3MySet() KMySet { return KMySet{N: 0} }
+func f4MySet() NMySet { return f2MySet().NMySet }
+func f5MySet() int    { return f3MySet().N }
+func f6MySet() {
+	N := 0
+	_ = N
+}
+
+var AVar1MySet int
+var aVar2MySet int
+var (
+	AVar3MySet int
+	aVar4MySet int
+)
+`,
+	},
+	{
+		title:   "Simple test private",
+		args:    "mySet(float64)",
+		pkg:     "main",
+		in:      basicTest,
+		dest:    map[string]string{"stubs.go": "package main\n\nfunc f0(a float64)  {}\nfunc F1(a *float64) {}\nfunc N(a *float64)  {}\nfunc O(a *float64)  {}\n"},
+		outName: "gotemplate_mySet.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+func init() {}
+
+type mySet struct{ a float64 }
+
+func newMySet(a float64) float64      { return float64(0) }
+func newSizedMySet(a float64) float64 { return float64(1) }
+func utilityFunc1MySet()              {}
+func utilityFuncMySet()               {}
+
+type nMySet struct{}
+type mMySet struct{ nMySet }
+type kMySet struct{ N int }
+
+func f2MySet() mMySet { return mMySet{nMySet: nMySet{}} }
+func f3MySet() kMySet { return kMySet{N: 0} }
+func f4MySet() nMySet { return f2MySet().nMySet }
+func f5MySet() int    { return f3MySet().N }
+func f6MySet() {
+	N := 0
+	_ = N
+}
+
+var aVar1MySet int
+var aVar2MySet int
+var (
+	aVar3MySet int
+	aVar4MySet int
+)
+`,
+	},
+	{
+		title: "Test function",
+		args:  "Min(int8, func(a int8, b int8) bool { return a < b })",
+		pkg:   "main",
+		in: `package tt
+
+// template type TT(A, Less)
+type A int
+func Less(a, b A) bool { return a < b }
+
+func TT(a, b A) A {
+	if Less(a, b) {
+		return a
+	}
+	return b
+}
+`,
+		outName: "gotemplate_Min.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+func Min(a, b int8) int8 {
+	if func(a int8, b int8) bool {
+		return a < b
+	}(a, b) {
+		return a
+	}
+	return b
+}
+`,
+	},
+	{
+		title: "Test comments",
+		args:  "IntHeap(int, func(a, b int) bool { return a > b })",
+		pkg:   "main",
+		in: `package tt
+
+// template type Heap(A, Less)
+
+// An A is the element of the heap
+type A int
+
+// Less compares two A values
+func Less(a, b A) bool { return a < b }
+
+// A Heap is a heap of [A] ordered by [Less]
+//
+// Make one with [NewHeap]. Heaps are not safe for concurrent use.
+type Heap []A
+
+// NewHeap makes a new [Heap] - see also Heap.Push
+func NewHeap() Heap { return nil }
+
+// Push adds a to the Heap using Less
+func (h *Heap) Push(a A) { *h = append(*h, a) }
+`,
+		outName: "gotemplate_IntHeap.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+// template type Heap(A, Less)
+
+// A IntHeap is a heap of [int] ordered by Less
+//
+// Make one with [NewIntHeap]. Heaps are not safe for concurrent use.
+type IntHeap []int
+
+// NewIntHeap makes a new [IntHeap] - see also IntHeap.Push
+func NewIntHeap() IntHeap { return nil }
+
+// Push adds a to the IntHeap using Less
+func (h *IntHeap) Push(a int) { *h = append(*h, a) }
+`,
+	},
+	{
+		title: "Test named arguments",
+		args:  `MyHeap(Less = func(a, b string) bool { return a > b || a == "=" }, A=string)`,
+		pkg:   "main",
+		in: `package tt
+
+// template type Heap(A, Less)
+type A int
+
+func Less(a, b A) bool { return a < b }
+
+type Heap []A
+
+func (h Heap) Less(i, j int) bool { return Less(h[i], h[j]) }
+`,
+		outName: "gotemplate_MyHeap.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type MyHeap []string
+
+func (h MyHeap) Less(i, j int) bool {
+	return func(a, b string) bool {
+		return a > b || a == "="
+	}(h[i], h[j])
+}
+`,
+	},
+	{
+		title: "Test default arguments",
+		args:  "MyHeap(string)",
+		pkg:   "main",
+		in: `package tt
+
+// template type Heap(A, Less = defaultLess)
+type A int
+
+func Less(a, b A) bool { return a < b }
+
+// defaultLess orders the elements ascending
+func defaultLess(a, b A) bool { return a < b }
+
+type Heap []A
+
+// Compares the elements with Less
+func (h Heap) Less(i, j int) bool { return Less(h[i], h[j]) }
+`,
+		outName: "gotemplate_MyHeap.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+// defaultLessMyHeap orders the elements ascending
+func defaultLessMyHeap(a, b string) bool { return a < b }
+
+type MyHeap []string
+
+// Compares the elements with Less
+func (h MyHeap) Less(i, j int) bool { return defaultLessMyHeap(h[i], h[j]) }
+`,
+	},
+	{
+		title: "Test stub defaults",
+		args:  "MyHeap(float64)",
+		pkg:   "main",
+		in: `package tt
+
+// template type Heap(A, Less)
+type A int
+
+func Less(a, b A) bool { return a < b }
+
+type Heap []A
+
+func (h Heap) Less(i, j int) bool { return Less(h[i], h[j]) }
+`,
+		outName: "gotemplate_MyHeap.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+func LessMyHeap(a, b float64) bool { return a < b }
+
+type MyHeap []float64
+
+func (h MyHeap) Less(i, j int) bool { return LessMyHeap(h[i], h[j]) }
+`,
+	},
+	{
+		title: "Simple Test constants",
+		args:  "Vector2(float32, 2)",
+		pkg:   "main",
+		in: `package tt
+
+// template type Vector(A, n)
+type A float32
+const n = 3
+
+type Vector [n]A
+
+func (v Vector) Add(b Vector) {
+	for i := range v {
+		v[i] += b[i]
+	}
+}
+`,
+		outName: "gotemplate_Vector2.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type Vector2 [2]float32
+
+func (v Vector2) Add(b Vector2) {
+	for i := range v {
+		v[i] += b[i]
+	}
+}
+`,
+	},
+	{
+		title: "Test constants",
+		args:  "Matrix22(float32, 2, 2)",
+		pkg:   "main",
+		in: `package mat
+
+// template type Matrix(A, n, m)
+type A float32
+
+const (
+	n, a, b, m = 1, 2, 3, 1
+)
+
+type Matrix [n][m]A
+
+func (mat Matrix) Add(x Matrix) {
+	for i := range mat {
+		for j := range mat[i] {
+			mat[i][j] += x[i][j]
+		}
+	}
+}
+`,
+		outName: "gotemplate_Matrix22.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+const (
+	aMatrix22, bMatrix22 = 2, 3
+)
+
+type Matrix22 [2][2]float32
+
+func (mat Matrix22) Add(x Matrix22) {
+	for i := range mat {
+		for j := range mat[i] {
+			mat[i][j] += x[i][j]
+		}
+	}
+}
+`,
+	},
+	{
+		title: "Test vars",
+		args:  "ProgXX(xx1, xx2, xx3, xx4, xx5, xx6)",
+		pkg:   "main",
+		in: `package prog
+
+// template type Prog(a, b, c, d, e, f)
+type A float32
+
+var (
+	a, z = 1, 2
+	b, n, m, c = 3, 4, 5, 6
+	d = 7
+)
+
+var (
+	o = 8
+	e = 8
+)
+
+var (
+	oo = 9
+)
+
+var (
+	p, f, q = 11, 12, 13
+)
+
+func Prog() int {return a+b+c+d+e+f}
+`,
+		outName: "gotemplate_ProgXX.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+// template type Prog(a, b, c, d, e, f)
+type AProgXX float32
+
+var (
+	zProgXX          = 2
+	nProgXX, mProgXX = 4, 5
+)
+
+var (
+	oProgXX = 8
+)
+
+var (
+	ooProgXX = 9
+)
+
+var (
+	pProgXX, qProgXX = 11, 13
+)
+
+func ProgXX() int { return xx1 + xx2 + xx3 + xx4 + xx5 + xx6 }
+`,
+	},
+	{
+		title: "Test complex type decls",
+		args:  "tmpl(int, string, map[string]map[string]chan int, float32, rune, chan []string)",
+		pkg:   "main",
+		in: `package tt
+
+// template type TMPL(A, B, C, D, E, F)
+type A int
+
+type TMPL struct {
+	a A
+	b B
+	c C
+	d D
+	e E
+	f F
+}
+
+type ImportantType bool
+
+type (
+	ImportantType1 int
+	B struct {
+		v map[int][][][]rune
+	}
+	importantType2 map[int]int
+	C chan struct {
+		x []string
+	}
+)
+
+type (
+	D rune
+	importantType3 struct{}
+	E string
+	F map[string]int
+)
+`,
+		outName: "gotemplate_tmpl.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type tmpl struct {
+	a int
+	b string
+	c map[string]map[string]chan int
+	d float32
+	e rune
+	f chan []string
+}
+
+type importantTypeTmpl bool
+
+type (
+	importantType1Tmpl int
+
+	importantType2Tmpl map[int]int
+)
+
+type (
+	importantType3Tmpl struct{}
+)
+`,
+	},
+	{
+		title: "Multiple files merged",
+		args:  "StringCache(string)",
+		pkg:   "main",
+		inFiles: map[string]string{
+			"cache.go": `package cache
+
+import "sync"
+
+// template type Cache(A)
+type A int
+
+// Cache holds A values
+type Cache struct {
+	mu sync.Mutex
+	m  map[A]*entry
+}
+
+func NewCache() *Cache { return &Cache{m: map[A]*entry{}} }
+`,
+			"entry.go": `package cache
+
+import "fmt"
+
+type entry struct{ v A }
+
+func (e *entry) String() string { return fmt.Sprint(e.v) }
+`,
+		},
+		outName: "gotemplate_StringCache.go",
+		out: `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// StringCache holds A values
+type StringCache struct {
+	mu sync.Mutex
+	m  map[string]*entryStringCache
+}
+
+func NewStringCache() *StringCache { return &StringCache{m: map[string]*entryStringCache{}} }
+
+type entryStringCache struct{ v string }
+
+func (e *entryStringCache) String() string { return fmt.Sprint(e.v) }
+`,
+	},
+	{
+		title: "Multiple files split",
+		args:  "IntCache(int)",
+		pkg:   "main",
+		split: true,
+		inFiles: map[string]string{
+			"cache.go": `package cache
+
+// template type Cache(A)
+type A int
+
+type Cache map[A]entry
+`,
+			"entry.go": `package cache
+
+import "fmt"
+
+type entry struct{ v A }
+
+func (e entry) String() string { return fmt.Sprint(e.v) }
+`,
+		},
+		outFiles: map[string]string{
+			"gotemplate_IntCache_cache.go": `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+type IntCache map[int]entryIntCache
+`,
+			"gotemplate_IntCache_entry.go": `// Code generated by gotemplate. DO NOT EDIT.
+
+package main
+
+import "fmt"
+
+type entryIntCache struct{ v int }
+
+func (e entryIntCache) String() string { return fmt.Sprint(e.v) }
+`,
+		},
+	},
+}
+
+// makeModules makes a module "input" containing the template package
+// made from inFiles and a module "output" with an empty main package to
+// instantiate it into which uses "input" via a replace directive.  It
+// returns the output directory and a cleanup function.
+func makeModules(t *testing.T, inFiles map[string]string) (output string, cleanup func()) {
+	// Make temporary directory
+	dir, err := ioutil.TempDir("", "gotemplate_test")
+	if err != nil {
+		t.Fatalf("Failed to make temp dir: %v", err)
+	}
+	cleanup = func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			t.Logf("Failed to remove temp dir: %v", err)
+		}
+	}
+
+	// Make subdirectories
+	input := path.Join(dir, "input")
+	err = os.Mkdir(input, 0700)
+	if err != nil {
+		t.Fatalf("Failed to make dir %q: %v", input, err)
+	}
+	output = path.Join(dir, "output")
+	err = os.Mkdir(output, 0700)
+	if err != nil {
+		t.Fatalf("Failed to make dir %q: %v", output, err)
+	}
+
+	files := map[string]string{
+		path.Join(input, "go.mod"):  "module input\n\ngo 1.22\n",
+		path.Join(output, "go.mod"): "module output\n\ngo 1.22\n\nrequire input v0.0.0\n\nreplace input => ../input\n",
+		// main.go for output
+		path.Join(output, "main.go"): "package main",
+	}
+	// template input
+	for name, in := range inFiles {
+		files[path.Join(input, name)] = in
+	}
+	for name, contents := range files {
+		err = ioutil.WriteFile(name, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write %q: %v", name, err)
+		}
+	}
+	return output, cleanup
+}
+
+func testTemplate(t *testing.T, test *TestTemplate) {
+	inFiles := map[string]string{}
+	if test.in != "" {
+		inFiles["main.go"] = test.in
+	}
+	for name, in := range test.inFiles {
+		inFiles[name] = in
+	}
+	output, cleanup := makeModules(t, inFiles)
+	defer cleanup()
+	for name, src := range test.dest {
+		writeFile(t, path.Join(output, name), src)
+	}
+
+	// Instantiate template
+	_, err := Instantiate(context.Background(), Options{
+		Dir:      output,
+		Package:  "input",
+		Instance: test.args,
+		RawName:  true,
+		Split:    test.split,
+	})
+	if err != nil {
+		t.Fatalf("Instantiate failed: %v", err)
+	}
+
+	// Check output
+	outFiles := map[string]string{}
+	if test.outName != "" {
+		outFiles[test.outName] = test.out
+	}
+	for name, out := range test.outFiles {
+		outFiles[name] = out
+	}
+	for name, out := range outFiles {
+		checkOutput(t, path.Join(output, name), out)
+	}
+}
+
+// stripProvenance removes the provenance line from the header of src
+func stripProvenance(src string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(src, "\n") {
+		if !strings.HasPrefix(line, provenancePrefix) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func checkOutput(t *testing.T, expectedFile, expected string) {
+	actualBytes, err := ioutil.ReadFile(expectedFile)
+	if err != nil {
+		t.Fatalf("Failed to read %q: %v", expectedFile, err)
+	}
+	// The provenance header is checked in TestProvenance
+	actual := stripProvenance(string(actualBytes))
+	if actual != expected {
+		t.Errorf(`Output is wrong
+Got
+-------------
+%s
+-------------
+Expected
+-------------
+%s
+-------------
+`, actual, expected)
+		actualFile := expectedFile + ".actual"
+		err = ioutil.WriteFile(actualFile, []byte(expected), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write %q: %v", actualFile, err)
+		}
+		cmd := exec.Command("diff", "-u", actualFile, expectedFile)
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		_ = cmd.Run()
+		t.Errorf("Diff\n----\n%s", out.String())
+	}
+}
+
+func TestSub(t *testing.T) {
+	for i := range tests {
+		t.Logf("Test[%d] %q", i, tests[i].title)
+		testTemplate(t, &tests[i])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const in = `package tt
+
+// template type Set(A)
+type A int
+
+type Set map[A]struct{}
+`
+	for _, test := range []struct {
+		title string
+		in    string
+		args  string
+		check func(error) bool
+	}{
+		{
+			title: "bad instance",
+			in:    in,
+			args:  "MySet(",
+			check: func(err error) bool { var e *ParseError; return errors.As(err, &e) },
+		},
+		{
+			title: "instance not a call",
+			in:    in,
+			args:  "MySet",
+			check: func(err error) bool { var e *ParseError; return errors.As(err, &e) && e.Err == errExpectingCall },
+		},
+		{
+			title: "arity",
+			in:    in,
+			args:  "MySet(int, string)",
+			check: func(err error) bool { var e *ArityError; return errors.As(err, &e) && e.Want == 1 && e.Got == 2 },
+		},
+		{
+			title: "missing argument without default",
+			in:    in,
+			args:  "MySet()",
+			check: func(err error) bool { var e *ArityError; return errors.As(err, &e) && e.Want == 1 && e.Got == 0 },
+		},
+		{
+			title: "unknown named argument",
+			in:    in,
+			args:  "MySet(B=int)",
+			check: func(err error) bool { var e *ArgumentError; return errors.As(err, &e) && e.Name == "B" },
+		},
+		{
+			title: "named argument given twice",
+			in:    in,
+			args:  "MySet(int, A=string)",
+			check: func(err error) bool {
+				var e *ArgumentError
+				return errors.As(err, &e) && e.Name == "A" && e.Reason == "given more than once"
+			},
+		},
+		{
+			title: "positional after named argument",
+			in:    in,
+			args:  "MySet(A=int, string)",
+			check: func(err error) bool { var e *ParseError; return errors.As(err, &e) && e.Err == errPositionalAfterNamed },
+		},
+		{
+			title: "type check",
+			in:    in + "var x A = \"potato\"\n",
+			args:  "MySet(int)",
+			check: func(err error) bool { var e *TypeCheckError; return errors.As(err, &e) && len(e.Errs) > 0 },
+		},
+		{
+			title: "no template comment",
+			in:    "package tt\n\ntype Set int\n",
+			args:  "MySet(int)",
+			check: func(err error) bool { var e *MissingDefinitionError; return errors.As(err, &e) && e.Name == "" },
+		},
+		{
+			title: "no template type",
+			in:    "package tt\n\n// template type Set(A)\ntype A int\n",
+			args:  "MySet(int)",
+			check: func(err error) bool { var e *MissingDefinitionError; return errors.As(err, &e) && e.Name == "Set" },
+		},
+	} {
+		output, cleanup := makeModules(t, map[string]string{"main.go": test.in})
+		_, err := Instantiate(context.Background(), Options{
+			Dir:      output,
+			Package:  "input",
+			Instance: test.args,
+		})
+		cleanup()
+		if err == nil {
+			t.Errorf("%s: expecting error", test.title)
+		} else if !test.check(err) {
+			t.Errorf("%s: wrong error %T: %v", test.title, err, err)
+		}
+	}
+}
+
+const multiTemplate = `package tt
+
+import "fmt"
+
+// template type TreeMap(Key, Value)
+// template type TreeSet(Key)
+type Key int
+type Value string
+
+// TreeMap maps Key to Value
+type TreeMap struct {
+	root *node
+}
+
+// Get a Value
+func (m *TreeMap) Get(k Key) Value {
+	return Value(fmt.Sprint(k))
+}
+
+// NewTreeMap makes a TreeMap
+func NewTreeMap() *TreeMap { return &TreeMap{} }
+
+// TreeSet is a set of Key
+type TreeSet struct {
+	root *node
+}
+
+// Has returns true if k is in the set
+func (s *TreeSet) Has(k Key) bool {
+	return s.root.find(k) != nil
+}
+
+// NewTreeSet makes a TreeSet
+func NewTreeSet() *TreeSet { return &TreeSet{} }
+
+// node is shared by both
+type node struct {
+	key         Key
+	left, right *node
+}
+
+func (n *node) find(k Key) *node {
+	if n == nil || n.key == k {
+		return n
+	}
+	if k < n.key {
+		return n.left.find(k)
+	}
+	return n.right.find(k)
+}
+`
+
+func TestMultipleDefinitions(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": multiTemplate})
+	defer cleanup()
+
+	g := New()
+	opts := Options{Dir: output, Package: "input", Instance: "IntSet = TreeSet(int)", RawName: true}
+	result, err := g.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	got := string(result.Files[0].Content)
+	got = got[strings.Index(got, "\n\n")+2:]
+	want := `package main
+
+// IntSet is a set of Key
+type IntSet struct {
+	root *nodeIntSet
+}
+
+// Has returns true if k is in the set
+func (s *IntSet) Has(k int) bool {
+	return s.root.find(k) != nil
+}
+
+// NewIntSet makes a IntSet
+func NewIntSet() *IntSet { return &IntSet{} }
+
+// nodeIntSet is shared by both
+type nodeIntSet struct {
+	key         int
+	left, right *nodeIntSet
+}
+
+func (n *nodeIntSet) find(k int) *nodeIntSet {
+	if n == nil || n.key == k {
+		return n
+	}
+	if k < n.key {
+		return n.left.find(k)
+	}
+	return n.right.find(k)
+}
+`
+	if got != want {
+		t.Errorf("Output wrong\nGot:\n%s\nWant:\n%s", got, want)
+	}
+	p, err := ReadProvenance(result.Files[0].Content)
+	if err != nil || p == nil {
+		t.Fatalf("ReadProvenance failed: %v", err)
+	}
+	if p.Definition != "TreeSet" {
+		t.Errorf("Definition = %q", p.Definition)
+	}
+	regen, err := p.Options(output)
+	if err != nil {
+		t.Fatalf("Options failed: %v", err)
+	}
+	if regen.Instance != "IntSet = TreeSet(int)" {
+		t.Errorf("Instance = %q", regen.Instance)
+	}
+
+	// The other template keeps its import
+	opts.Instance = "StringMap = TreeMap(int, string)"
+	result, err = g.Generate(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	got = string(result.Files[0].Content)
+	for _, s := range []string{`import "fmt"`, "type StringMap struct", "func NewStringMap()"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("Expecting %q in\n%s", s, got)
+		}
+	}
+	if strings.Contains(got, "NewTreeSet") || strings.Contains(got, "Has(") {
+		t.Errorf("TreeSet wasn't removed\n%s", got)
+	}
+
+	for _, instance := range []string{"IntSet(int)", "IntSet = TreeHeap(int)", "IntSet = TreeMap(int)"} {
+		opts.Instance = instance
+		if _, err := g.Generate(context.Background(), opts); err == nil {
+			t.Errorf("%s: expecting error", instance)
+		}
+	}
+}
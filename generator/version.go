@@ -0,0 +1,84 @@
+// Checks the go version of the destination module allows the arguments
+
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"go/version"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// genericsVersion is the first go version with generics
+const genericsVersion = "go1.18"
+
+// matchGoDirective matches the go directive of a go.mod file
+var matchGoDirective = regexp.MustCompile(`(?m)^go\s+(\S+)\s*(?://.*)?$`)
+
+// moduleGoVersion returns the go version of the module dir is in, as
+// in "go1.21", or "" if it isn't in a module.  Modules without a go
+// directive are go1.16.
+func moduleGoVersion(dir string) (string, error) {
+	root := moduleRoot(dir)
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	m := matchGoDirective.FindSubmatch(data)
+	if m == nil {
+		return "go1.16", nil
+	}
+	return "go" + string(m[1]), nil
+}
+
+// isInstantiation returns true if the type argument arg instantiates a
+// generic type, as in Pair[int, string]
+func isInstantiation(arg string) bool {
+	x, err := parser.ParseExpr(arg)
+	if err != nil {
+		return false
+	}
+	found := false
+	ast.Inspect(x, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.IndexExpr, *ast.IndexListExpr:
+			found = true
+		case *ast.FuncLit:
+			// Indexing in the body isn't instantiation
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// checkGoVersion checks that the module of the destination package has
+// a go version with generics if the arguments of the type parameters
+// instantiate generic types
+func (t *template) checkGoVersion(pkg *typedPackage) error {
+	var generic string
+	for i, param := range t.templateArgs {
+		arg := t.Args[i]
+		if _, isType := pkg.types.Scope().Lookup(param).(*types.TypeName); isType && arg != "" && isInstantiation(arg) {
+			generic = arg
+			break
+		}
+	}
+	if generic == "" {
+		return nil
+	}
+	goVersion, err := moduleGoVersion(t.opts.OutDir)
+	if err != nil {
+		return err
+	}
+	if goVersion != "" && version.IsValid(goVersion) && version.Compare(goVersion, genericsVersion) < 0 {
+		return fmt.Errorf("argument %s is a generic type which needs %s but the go.mod of %s has %s", generic, genericsVersion, t.opts.OutDir, goVersion)
+	}
+	return nil
+}
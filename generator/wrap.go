@@ -0,0 +1,377 @@
+// Writes instances as aliases and wrappers of a generic package
+
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadGeneric loads the generic package of the Generic option
+func (t *template) loadGeneric() (*packages.Package, error) {
+	conf := &packages.Config{
+		Context: t.ctx,
+		Mode:    packages.NeedName | packages.NeedTypes,
+		Dir:     t.opts.Dir,
+	}
+	pkgs, err := packages.Load(conf, t.opts.Generic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load generic package %s: %w", t.opts.Generic, err)
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expecting 1 package for %q but found %d", t.opts.Generic, len(pkgs))
+	}
+	if len(pkgs[0].Errors) > 0 {
+		return nil, fmt.Errorf("failed to load generic package %s: %v", t.opts.Generic, pkgs[0].Errors[0])
+	}
+	return pkgs[0], nil
+}
+
+// outputGeneric writes a file which declares the top level names of
+// the instance as aliases of the types of the generic package
+// instantiated with the arguments, and functions which call its
+// functions.  The arguments of the value parameters, which the
+// generic functions take as their leading parameters, are passed
+// along.  decls maps the objects of the template to their names in
+// the template.  Names unexported in the template can't be reached in
+// the generic package so are left out, as are vars which can't be
+// aliased.  It is an error if the generic package has no place for
+// any of the arguments or the file doesn't compile in the destination
+// package.
+func (t *template) outputGeneric(pkg *typedPackage, decls map[types.Object]string) error {
+	generic, err := t.loadGeneric()
+	if err != nil {
+		return err
+	}
+	fset, info := pkg.fset, pkg.info
+
+	// Name the generic package so it doesn't clash with the imports
+	// or the declarations of the instance
+	taken := map[string]bool{}
+	var imports []string
+	for _, f := range pkg.files {
+		for _, spec := range f.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			name := ""
+			if spec.Name != nil {
+				name = spec.Name.Name
+				taken[name] = true
+			}
+			for _, imp := range pkg.types.Imports() {
+				if imp.Path() == importPath && name == "" {
+					taken[imp.Name()] = true
+				}
+			}
+			imports = append(imports, strings.TrimSpace(name+" "+spec.Path.Value))
+		}
+	}
+	for _, name := range t.importNames {
+		taken[name] = true
+	}
+	ids := instanceDecls(pkg.files)
+	for _, id := range ids {
+		taken[id.Name] = true
+	}
+	name := generic.Name
+	for n := 2; taken[name]; n++ {
+		name = generic.Name + strconv.Itoa(n)
+	}
+	if name == path.Base(generic.PkgPath) {
+		imports = append(imports, strconv.Quote(generic.PkgPath))
+	} else {
+		imports = append(imports, name+" "+strconv.Quote(generic.PkgPath))
+	}
+
+	type wrapper struct {
+		pos  token.Pos
+		decl string
+	}
+	var wrappers []wrapper
+	var vars, missing []string
+	used := map[string]bool{} // the template parameters passed on
+	for _, id := range ids {
+		obj := info.Defs[id]
+		templateName, found := decls[obj]
+		if !found {
+			continue
+		}
+		if !ast.IsExported(templateName) {
+			t.debugf("Leaving out %s as %s is unexported in the generic package", id.Name, templateName)
+			continue
+		}
+		genericObj := generic.Types.Scope().Lookup(templateName)
+		if genericObj == nil {
+			missing = append(missing, templateName)
+			continue
+		}
+		var decl string
+		switch obj := obj.(type) {
+		case *types.TypeName:
+			var args string
+			if named, ok := genericObj.Type().(*types.Named); ok {
+				args, err = t.typeArgs(named.TypeParams(), templateName, used)
+				if err != nil {
+					return err
+				}
+			}
+			decl = fmt.Sprintf("type %s = %s.%s%s", id.Name, name, templateName, args)
+		case *types.Const:
+			decl = fmt.Sprintf("const %s = %s.%s", id.Name, name, templateName)
+		case *types.Func:
+			if isTestFunc(obj) {
+				continue
+			}
+			fd := funcDecl(pkg.files, id)
+			if fd == nil {
+				continue
+			}
+			decl, err = t.wrapFunc(fset, pkg.types.Scope(), fd, genericObj, name, used)
+			if err != nil {
+				return err
+			}
+		default:
+			vars = append(vars, id.Name)
+			continue
+		}
+		if doc := declDoc(pkg.files, id); doc != nil {
+			decl = commentText(doc) + decl
+		}
+		wrappers = append(wrappers, wrapper{id.Pos(), decl})
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("generic package %s doesn't declare %s", generic.PkgPath, strings.Join(missing, ", "))
+	}
+	var unused []string
+	for _, param := range t.templateArgs {
+		if !used[param] {
+			unused = append(unused, fmt.Sprintf("%s for %s", t.templateArgsMap[param], param))
+		}
+	}
+	if len(unused) > 0 {
+		return fmt.Errorf("generic package %s has no parameter for %s of %s", generic.PkgPath, strings.Join(unused, ", "), t.Name)
+	}
+	if len(vars) > 0 {
+		t.opts.Logf("%s: can't wrap vars %s of %s", t.Name, strings.Join(vars, ", "), generic.PkgPath)
+	}
+	sort.SliceStable(wrappers, func(i, j int) bool {
+		return wrappers[i].pos < wrappers[j].pos
+	})
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "package %s\n\n", t.NewPackage)
+	fmt.Fprintf(&src, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	fmt.Fprintf(&src, "// %s is %s instantiated with %s\n\n", t.Name, generic.PkgPath, strings.Join(t.Args, ", "))
+	for _, w := range wrappers {
+		fmt.Fprintf(&src, "%s\n\n", w.decl)
+	}
+	if err := t.output(t.outputName(".go"), src.Bytes()); err != nil {
+		return err
+	}
+	return t.checkWrapper(t.files[len(t.files)-1])
+}
+
+// checkWrapper type checks the destination package with the wrapper
+// file f in place of any file already at its path, returning the
+// first error in f
+func (t *template) checkWrapper(f File) error {
+	path, err := filepath.Abs(f.Path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		t.debugf("Not checking %s as its directory doesn't exist yet", f.Path)
+		return nil
+	}
+	conf := &packages.Config{
+		Context: t.ctx,
+		Mode:    packages.LoadSyntax,
+		Dir:     filepath.Dir(path),
+		Overlay: map[string][]byte{path: f.Content},
+	}
+	pkgs, err := packages.Load(conf, ".")
+	if err != nil {
+		return fmt.Errorf("failed to load destination package: %w", err)
+	}
+	for _, p := range pkgs {
+		for _, e := range p.Errors {
+			if strings.HasPrefix(e.Pos, path+":") {
+				return fmt.Errorf("the wrappers of %s don't compile: %v", t.Name, e)
+			}
+		}
+	}
+	return nil
+}
+
+// typeArgs returns the arguments for the type parameters params of
+// the generic declaration name, as in "[string]", matching them to the
+// template parameters by name and recording them in used
+func (t *template) typeArgs(params *types.TypeParamList, name string, used map[string]bool) (string, error) {
+	if params.Len() == 0 {
+		return "", nil
+	}
+	var args []string
+	for i := 0; i < params.Len(); i++ {
+		param := params.At(i).Obj().Name()
+		arg, found := t.templateArgsMap[param]
+		if !found {
+			return "", fmt.Errorf("no argument for type parameter %s of generic %s", param, name)
+		}
+		used[param] = true
+		args = append(args, arg)
+	}
+	return "[" + strings.Join(args, ", ") + "]", nil
+}
+
+// wrapFunc makes a function with the name and signature of the
+// function d of the instance which calls the generic function obj of
+// the generic package imported as pkgName, recording the template
+// parameters it passes on in used
+func (t *template) wrapFunc(fset *token.FileSet, scope *types.Scope, d *ast.FuncDecl, obj types.Object, pkgName string, used map[string]bool) (string, error) {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return "", fmt.Errorf("%s of the generic package isn't a function", obj.Name())
+	}
+	sig := fn.Type().(*types.Signature)
+	typeArgs, err := t.typeArgs(sig.TypeParams(), fn.Name(), used)
+	if err != nil {
+		return "", err
+	}
+
+	args := nameParams(d)
+
+	// The generic function takes the value parameters first
+	extra := sig.Params().Len() - len(args)
+	var values []string
+	for i := 0; i < extra; i++ {
+		name := sig.Params().At(i).Name()
+		value := ""
+		for _, param := range t.templateArgs {
+			if _, isType := scope.Lookup(param).(*types.TypeName); isType {
+				continue
+			}
+			if strings.TrimRight(name, "0123456789") == lowerFirst(param) {
+				value = t.templateArgsMap[param]
+				used[param] = true
+				break
+			}
+		}
+		if value == "" {
+			return "", fmt.Errorf("no argument for parameter %s of generic %s", name, fn.Name())
+		}
+		values = append(values, value)
+	}
+
+	return forwardFunc(fset, d, fmt.Sprintf("%s.%s%s(%s)", pkgName, fn.Name(), typeArgs, strings.Join(append(values, args...), ", ")))
+}
+
+// nameParams names the unnamed parameters of d so they can be passed
+// on, returning them as the arguments of a call
+func nameParams(d *ast.FuncDecl) (args []string) {
+	i := 0
+	for _, field := range d.Type.Params.List {
+		if len(field.Names) == 0 {
+			field.Names = []*ast.Ident{ast.NewIdent("")}
+		}
+		for _, id := range field.Names {
+			if id.Name == "" || id.Name == "_" {
+				id.Name = fmt.Sprintf("p%d", i)
+			}
+			arg := id.Name
+			if _, variadic := field.Type.(*ast.Ellipsis); variadic {
+				arg += "..."
+			}
+			args = append(args, arg)
+			i++
+		}
+	}
+	return args
+}
+
+// forwardFunc makes a function with the name and signature of d whose
+// body is call, returning its results if d has any
+func forwardFunc(fset *token.FileSet, d *ast.FuncDecl, call string) (string, error) {
+	var signature bytes.Buffer
+	if err := format.Node(&signature, fset, d.Type); err != nil {
+		return "", fmt.Errorf("failed to format %s: %w", d.Name.Name, err)
+	}
+	if d.Type.Results != nil && len(d.Type.Results.List) > 0 {
+		call = "return " + call
+	}
+	return fmt.Sprintf("func %s%s {\n\t%s\n}", d.Name.Name, strings.TrimPrefix(signature.String(), "func"), call), nil
+}
+
+// funcDecl returns the declaration of the function named by id
+func funcDecl(files []*ast.File, id *ast.Ident) *ast.FuncDecl {
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if d, ok := decl.(*ast.FuncDecl); ok && d.Name == id {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// declDoc returns the doc comment of the declaration of id, that of
+// its spec or of the declaration if it has only one spec
+func declDoc(files []*ast.File, id *ast.Ident) *ast.CommentGroup {
+	var doc *ast.CommentGroup
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch d := n.(type) {
+			case *ast.FuncDecl:
+				if d.Name == id {
+					doc = d.Doc
+				}
+				return false
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					var specDoc *ast.CommentGroup
+					var names []*ast.Ident
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						specDoc, names = s.Doc, []*ast.Ident{s.Name}
+					case *ast.ValueSpec:
+						specDoc, names = s.Doc, s.Names
+					}
+					for _, name := range names {
+						if name != id {
+							continue
+						}
+						doc = specDoc
+						if doc == nil && len(d.Specs) == 1 {
+							doc = d.Doc
+						}
+					}
+				}
+				return false
+			}
+			return true
+		})
+	}
+	return doc
+}
+
+// commentText returns doc as // comments ending in a newline
+func commentText(doc *ast.CommentGroup) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(doc.Text(), "\n"), "\n") {
+		b.WriteString(strings.TrimRight("// "+line, " ") + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestGenericWrappers(t *testing.T) {
+	output, cleanup := makeModules(t, map[string]string{"main.go": migrateTemplate})
+	defer cleanup()
+	input := path.Join(path.Dir(output), "input")
+	g := New()
+	if _, err := g.Migrate(context.Background(), MigrateOptions{Dir: input, Package: ".", OutDir: "generic"}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(output, "main.go"), `package main
+
+func lessInt(a, b int) bool { return a > b }
+
+func main() {
+	s := NewIntSorted()
+	s.Add(1)
+	var _ *IntSorted = s
+	println(s.String())
+}
+`)
+
+	opts := Options{Dir: output, Package: "input", Instance: "IntSorted(int, lessInt)", Generic: "input/generic"}
+	result, err := g.Instantiate(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(result.Files[0].Content)
+	for _, want := range []string{
+		`"flags":["-generic","input/generic"]`,
+		`tt "input/generic"`,
+		"// A IntSorted holds As in order\ntype IntSorted = tt.Sorted[int]",
+		"func NewIntSorted() *IntSorted {\n\treturn tt.NewSorted[int](lessInt)\n}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expecting %q in\n%s", want, got)
+		}
+	}
+	for _, unwanted := range []string{"searchIntSorted", "func (s *IntSorted)"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("unexpected %q in\n%s", unwanted, got)
+		}
+	}
+
+	// The destination package still compiles
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.LoadSyntax, Dir: output}, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range pkgs[0].Errors {
+		t.Errorf("destination doesn't compile: %v", e)
+	}
+
+	// The generic package must declare the exported names
+	if err := os.Remove(path.Join(input, "generic", "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(input, "generic", "other.go"), "package tt\n\ntype Sorted[A any] []A\n")
+	if _, err := New().Generate(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "doesn't declare NewSorted") {
+		t.Errorf("expecting missing declaration error, got %v", err)
+	}
+
+	// The wrappers must compile
+	writeFile(t, path.Join(input, "generic", "other.go"), "package tt\n\ntype Sorted[A interface{ M() }] []A\n\nfunc NewSorted[A interface{ M() }](less func(a, b A) bool) *Sorted[A] { return nil }\n")
+	if _, err := New().Generate(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "the wrappers of IntSorted don't compile") {
+		t.Errorf("expecting compile error, got %v", err)
+	}
+}
+
+func TestGenericWrappersChecked(t *testing.T) {
+	heapSrc, err := os.ReadFile("../heap/heap.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, cleanup := makeModules(t, map[string]string{"main.go": string(heapSrc)})
+	defer cleanup()
+	input := path.Join(path.Dir(output), "input")
+	g := New()
+	if _, err := g.Migrate(context.Background(), MigrateOptions{Dir: input, Package: ".", OutDir: "generic", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path.Join(output, "main.go"), "package main\n\nfunc myLess(a, b int) bool { return a > b }\n")
+
+	// The generic heap has no place for myLess
+	opts := Options{Dir: output, Package: "input", Instance: "H(int, myLess)", Generic: "input/generic"}
+	if _, err := g.Generate(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "has no parameter for myLess for Less of H") {
+		t.Errorf("expecting unused argument error, got %v", err)
+	}
+
+}
@@ -1,11 +1,13 @@
 module github.com/sandwich-go/gotemplate
 
-go 1.17
+go 1.25.0
 
-require golang.org/x/tools v0.1.9
+// x/tools v0.44.0 is the first release which reads the export data
+// of the current Go toolchains, which go/packages needs to load the
+// dependencies of templates.  It requires go 1.25.0.
+require golang.org/x/tools v0.44.0
 
 require (
-	golang.org/x/mod v0.5.1 // indirect
-	golang.org/x/sys v0.0.0-20211019181941-9d821ace8654 // indirect
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	golang.org/x/mod v0.35.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
 )
@@ -25,6 +25,7 @@ package heap
 // An A is the element in the slice []A we are keeping as a heap
 //
 // template type Heap(A, Less)
+// template constraint Less func(A, A) bool
 type A int
 
 // Less is a function to compare two As
@@ -12,37 +12,34 @@ Path generation for generated files could do with work - args may have
 spaces in, may have upper and lower case characters which will fold
 together on Windows.
 
-Detect dupliace template definitions so we don't write them multiple times
-
 write some test
-
-manage all the generated files - find them - delete stale ones, etg
-
-Put comment in generated file, generated by gotemplate from xyz on date?
-
-do replacements in comments too?
 */
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"strings"
-
 	"log"
 	"os"
 	"path"
+
+	"github.com/sandwich-go/gotemplate/generator"
 )
 
 // Globals
 var (
 	// Flags
-	verbose = flag.Bool("v", false, "Verbose - print lots of stuff")
-	outfile = flag.String("outfmt", "gotemplate_%v", "the format of the output file; must contain a single instance of the %v verb\n"+
-		"\twhich will be replaced with the template instance name")
-	rawname = flag.Bool("r", false, "raw name, not snake case name")
-	test    = flag.Bool("t", false, "has test file")
+	opts   generator.Options
+	config = flag.String("config", "", "generate all the instances listed in this JSON manifest file")
+	check  = flag.Bool("check", false, "write nothing but fail with a diff if any generated file is out of date")
+	dryRun = flag.Bool("n", false, "write nothing but print the generated source and the names of the files it would be written to")
 )
 
+func init() {
+	opts.RegisterFlags(flag.CommandLine)
+	flag.BoolVar(dryRun, "stdout", false, "same as -n")
+}
+
 // Logging function
 var logf = log.Printf
 
@@ -52,31 +49,19 @@ var fatalf = func(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
-// Log if -v set
-func debugf(format string, args ...interface{}) {
-	if *verbose {
-		logf(format, args...)
-	}
-}
-
-func filename(n string) string {
-	if *rawname {
-		return n
-	}
-	return snakeCase(n)
-}
-
-func hasTestFile() bool {
-	return *test
-}
-
 // usage prints the syntax and exists
 func usage() {
 	BaseName := path.Base(os.Args[0])
 	fmt.Fprintf(os.Stderr,
-		"Syntax: %s [flags] package_name parameter\n\n"+
+		"Syntax: %s [flags] package_name parameter\n"+
+			"        %s [flags] -config gotemplate.json\n"+
+			"        %s clean [-l] [-config gotemplate.json] [dir...]\n"+
+			"        %s verify [-config gotemplate.json] [dir...]\n"+
+			"        %s regen [-check] [dir/...]\n"+
+			"        %s migrate [-o dir] [-n] [-f] package_name\n"+
+			"        %s wrap -generic package_name file...\n\n"+
 			"Flags:\n\n",
-		BaseName)
+		BaseName, BaseName, BaseName, BaseName, BaseName, BaseName, BaseName)
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "\n")
 	os.Exit(1)
@@ -86,27 +71,68 @@ func main() {
 	log.SetFlags(0)
 	log.SetPrefix("")
 
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 
-	// verify that *outfile contains exactly one occurrence of the %v verb
-	// and no other occurences of %
-	if c := strings.Replace(*outfile, "%v", "", 1); c == *outfile ||
-		strings.Index(c, "%") != -1 {
-
-		fatalf("Invalid outfile format")
-	}
-
 	args := flag.Args()
+	if *config != "" {
+		if len(args) != 0 {
+			fatalf("No arguments needed with -config")
+		}
+		generateManifest(*config)
+		return
+	}
 	if len(args) != 2 {
 		fatalf("Need 2 arguments, package and parameters")
 	}
 
-	cwd, err := os.Getwd()
+	opts.Package = args[0]
+	opts.Instance = args[1]
+	opts.Logf = logf
+	if *check {
+		verifyInstances([]generator.Options{opts})
+		return
+	}
+	if *dryRun {
+		printInstances([]generator.Options{opts})
+		return
+	}
+	_, err := generator.Instantiate(context.Background(), opts)
 	if err != nil {
-		fatalf("Couldn't get wd: %v", err)
+		fatalf("%v", err)
 	}
+}
 
-	t := newTemplate(cwd, args[0], args[1])
-	t.instantiate()
+// generateManifest generates all the instances in the manifest file
+func generateManifest(path string) {
+	m, err := generator.ReadManifest(path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	instances := m.Options()
+	for i := range instances {
+		instances[i].Verbose = opts.Verbose
+		instances[i].Logf = logf
+	}
+	if *check {
+		verifyInstances(instances)
+		return
+	}
+	if *dryRun {
+		printInstances(instances)
+		return
+	}
+	g := generator.New()
+	for _, o := range instances {
+		if _, err := g.Instantiate(context.Background(), o); err != nil {
+			fatalf("%s %s: %v", o.Package, o.Instance, err)
+		}
+	}
 }
@@ -6,6 +6,7 @@ package set
 // An A is the element of the set
 //
 // template type Set(A)
+// template constraint A comparable
 type A int
 
 // SetNothing is used as a zero sized member in the map
@@ -10,6 +10,7 @@ package sort
 // An A is the element in the slice []A we are sorting
 //
 // template type Sort(A, Less)
+// template constraint Less func(A, A) bool
 type A int
 
 // Less is a function to compare two As